@@ -98,6 +98,11 @@ type Client struct {
 	Key     string
 	Region  string
 	Profile string
+	// Endpoint Where the object is read from instead of S3, such as a fake object store in tests.
+	// Requests to it are made path style, as <endpoint>/<bucket>/<key>.
+	Endpoint string
+	// Credentials What requests are signed with instead of the Profile's shared credentials, if set.
+	Credentials *credentials.Credentials
 }
 
 // NewClient This function should be used to create a Client to avoid missing required fields.
@@ -113,10 +118,17 @@ func NewClient(bucket, key, region, profile string) Client {
 // GetObjectRange Fetches the range of bytes from the file located at the destination on AWS
 // derived from the Client's Bucket and Key fields.
 func (c Client) GetObjectRange(byteRange string) (io.ReadCloser, error) {
+	creds := c.Credentials
+	if creds == nil {
+		creds = credentials.NewSharedCredentials("", c.Profile)
+	}
 	cfg := (&aws.Config{
-		Credentials: credentials.NewSharedCredentials("", c.Profile),
+		Credentials: creds,
 		Region:      aws.String(c.Region),
 	}).WithHTTPClient(newHTTPClient())
+	if c.Endpoint != "" {
+		cfg = cfg.WithEndpoint(c.Endpoint).WithS3ForcePathStyle(true)
+	}
 	sess := session.New(cfg)
 	svc := s3.New(sess)
 	input := &s3.GetObjectInput{
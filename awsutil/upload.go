@@ -0,0 +1,147 @@
+// Copyright 2018 The MITRE Corporation
+// Author Matthew Bianchi
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsutil
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// GcsEndpoint Google Cloud Storage's S3 compatible XML API, which takes HMAC keys as credentials.
+const GcsEndpoint = "https://storage.googleapis.com"
+
+// DefaultPartSize How much of a stream an Uploader holds in memory at once, unless the stream is too big for it.
+// S3 won't take parts smaller than 5 MiB, other than the last.
+const DefaultPartSize = 16 * 1024 * 1024
+
+// MaxParts The most parts S3 takes in a multipart upload.
+const MaxParts = 10000
+
+// Uploader Copies streams into objects in a bucket a part at a time, so they never need to fit in memory or on disk.
+type Uploader struct {
+	Bucket   string
+	PartSize int64
+	svc      *s3.S3
+}
+
+// NewUploader Returns an Uploader for bucket using the credentials in profile.
+// An empty endpoint means AWS S3 in region, otherwise it's any S3 compatible endpoint, such as GcsEndpoint.
+func NewUploader(bucket, region, endpoint, profile string) *Uploader {
+	cfg := (&aws.Config{
+		Credentials: credentials.NewSharedCredentials("", profile),
+		Region:      aws.String(region),
+	}).WithHTTPClient(newHTTPClient())
+	if endpoint != "" {
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+	return &Uploader{
+		Bucket:   bucket,
+		PartSize: DefaultPartSize,
+		svc:      s3.New(session.New(cfg)),
+	}
+}
+
+// PartSizeFor Returns how big each part of a stream of size bytes is: PartSize,
+// or bigger if it would take more than MaxParts parts of PartSize. A size of 0 means it isn't known.
+func (u *Uploader) PartSizeFor(size uint64) int64 {
+	partSize := u.PartSize
+	if least := int64((size + MaxParts - 1) / MaxParts); least > partSize {
+		partSize = least
+	}
+	return partSize
+}
+
+// Upload Reads body, which holds size bytes or 0 if that isn't known, until EOF into the object at key,
+// replacing whatever was there. Once all of body is read, check is called, and only if it returns nil is the
+// object made, so a body found to be wrong is never seen at key.
+// Bodies that fit in one part are put in one request, anything bigger is uploaded in parts
+// and the upload is aborted if any of them fail.
+func (u *Uploader) Upload(key string, body io.Reader, size uint64, check func() error) error {
+	buf := make([]byte, u.PartSizeFor(size))
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := check(); err != nil {
+			return err
+		}
+		_, err = u.svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(u.Bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(buf[:n]),
+		})
+		return errors.Wrapf(err, "couldn't upload to bucket: %s key: %s", u.Bucket, key)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "couldn't read what was to be uploaded to bucket: %s key: %s", u.Bucket, key)
+	}
+
+	mpu, err := u.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(u.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "couldn't start multipart upload to bucket: %s key: %s", u.Bucket, key)
+	}
+	parts, err := u.uploadParts(key, mpu.UploadId, body, buf, n)
+	if err == nil {
+		err = check()
+	}
+	if err != nil {
+		u.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(u.Bucket),
+			Key:      aws.String(key),
+			UploadId: mpu.UploadId,
+		})
+		return err
+	}
+	_, err = u.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.Bucket),
+		Key:             aws.String(key),
+		UploadId:        mpu.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return errors.Wrapf(err, "couldn't finish multipart upload to bucket: %s key: %s", u.Bucket, key)
+}
+
+// uploadParts Uploads the first n bytes of buf as the first part, then the rest of body a part at a time.
+func (u *Uploader) uploadParts(key string, uploadID *string, body io.Reader, buf []byte, n int) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	for number := int64(1); n > 0; number++ {
+		if number > MaxParts {
+			return nil, errors.Errorf("couldn't upload to bucket: %s key: %s, it's bigger than %d parts of %d bytes", u.Bucket, key, MaxParts, len(buf))
+		}
+		out, err := u.svc.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(u.Bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(number),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't upload part %d to bucket: %s key: %s", number, u.Bucket, key)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(number)})
+		n, err = io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, errors.Wrapf(err, "couldn't read what was to be uploaded to bucket: %s key: %s", u.Bucket, key)
+		}
+	}
+	return parts, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 The MITRE Corporation
+// Author Matthew Bianchi
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awsutil_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mitre/fusera/awsutil"
+	"github.com/pkg/errors"
+)
+
+// fakeS3 Records the multipart upload requests made to it, and the objects made by them.
+type fakeS3 struct {
+	mu       sync.Mutex
+	requests []string
+	objects  map[string]int
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+	_, uploads := q["uploads"]
+	var op string
+	switch {
+	case r.Method == "POST" && uploads:
+		op = "create"
+		w.Write([]byte(`<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>`))
+	case r.Method == "PUT" && q.Get("partNumber") != "":
+		op = "part"
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == "POST":
+		op = "complete"
+		w.Write([]byte(`<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`))
+	case r.Method == "DELETE":
+		op = "abort"
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		op = "put"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, op)
+	if op == "complete" || op == "put" {
+		s.objects[r.URL.Path]++
+	}
+}
+
+// newUploader Returns an Uploader of parts of partSize to a fakeS3.
+func newUploader(t *testing.T, partSize int64) (*awsutil.Uploader, *fakeS3) {
+	t.Helper()
+	creds := filepath.Join(t.TempDir(), "credentials")
+	if err := ioutil.WriteFile(creds, []byte("[default]\naws_access_key_id = id\naws_secret_access_key = secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previous, had := os.LookupEnv("AWS_SHARED_CREDENTIALS_FILE")
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", creds)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv("AWS_SHARED_CREDENTIALS_FILE", previous)
+		} else {
+			os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+		}
+	})
+	s3 := &fakeS3{objects: make(map[string]int)}
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+	u := awsutil.NewUploader("bucket", "us-east-1", server.URL, "default")
+	u.PartSize = partSize
+	return u, s3
+}
+
+func TestPartSizeGrowsToFitMaxParts(t *testing.T) {
+	u, _ := newUploader(t, awsutil.DefaultPartSize)
+	tests := []struct {
+		size uint64
+		want int64
+	}{
+		{0, awsutil.DefaultPartSize},
+		{1024, awsutil.DefaultPartSize},
+		{awsutil.DefaultPartSize * awsutil.MaxParts, awsutil.DefaultPartSize},
+		{awsutil.DefaultPartSize*awsutil.MaxParts + 1, awsutil.DefaultPartSize + 1},
+		{5 << 40, (5<<40 + awsutil.MaxParts - 1) / awsutil.MaxParts},
+	}
+	for _, tt := range tests {
+		got := u.PartSizeFor(tt.size)
+		if got != tt.want {
+			t.Errorf("part size for %d bytes = %d, want %d", tt.size, got, tt.want)
+		}
+		if tt.size > 0 && uint64(got)*awsutil.MaxParts < tt.size {
+			t.Errorf("%d parts of %d bytes can't hold %d bytes", awsutil.MaxParts, got, tt.size)
+		}
+	}
+}
+
+func TestUploadIsCompletedOnlyIfChecked(t *testing.T) {
+	body := bytes.Repeat([]byte("x"), 10)
+	tests := []struct {
+		name     string
+		check    error
+		want     []string
+		complete bool
+	}{
+		{"good", nil, []string{"create", "part", "part", "part", "complete"}, true},
+		{"bad", errors.New("md5 mismatch"), []string{"create", "part", "part", "part", "abort"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, s3 := newUploader(t, 4)
+			checked := false
+			err := u.Upload("key", bytes.NewReader(body), uint64(len(body)), func() error {
+				checked = true
+				return tt.check
+			})
+			if errors.Cause(err) != tt.check {
+				t.Errorf("err = %v, want %v", err, tt.check)
+			}
+			if !checked {
+				t.Error("upload wasn't checked")
+			}
+			if !equal(s3.requests, tt.want) {
+				t.Errorf("requests = %v, want %v", s3.requests, tt.want)
+			}
+			if made := s3.objects["/bucket/key"] > 0; made != tt.complete {
+				t.Errorf("object made = %v, want %v", made, tt.complete)
+			}
+		})
+	}
+}
+
+func TestSmallUploadIsCheckedBeforePut(t *testing.T) {
+	u, s3 := newUploader(t, 1024)
+	err := u.Upload("key", bytes.NewReader([]byte("small")), 5, func() error { return errors.New("size mismatch") })
+	if err == nil {
+		t.Fatal("uploaded despite the check failing")
+	}
+	if len(s3.requests) != 0 {
+		t.Errorf("requests = %v, want none", s3.requests)
+	}
+	if err := u.Upload("key", bytes.NewReader([]byte("small")), 5, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !equal(s3.requests, []string{"put"}) {
+		t.Errorf("requests = %v, want a single put", s3.requests)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
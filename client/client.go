@@ -0,0 +1,130 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client sets up the SDL API client that every fusera and sracp command asking for accessions shares,
+// going by the token, accession, file type, location, and endpoint flags.
+package client
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/gps"
+	"github.com/mitre/fusera/info"
+	"github.com/mitre/fusera/sdl"
+	"github.com/pkg/errors"
+)
+
+// Client What to ask the SDL API for and where from.
+type Client struct {
+	Tokenpath  string
+	Token      []byte
+	Accessions []string
+	// Types The file types asked for, nil for all of them.
+	Types map[string]bool
+	// Locator and API are set by Locate.
+	Locator gps.Locator
+	API     *sdl.SDL
+}
+
+// New Returns a Client ready to ask the SDL API for accs, or the accessions given by flag if accs is nil.
+func New(accs []string) (*Client, error) {
+	c, err := Resolve(accs)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Locate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Resolve Returns a Client with the token, accessions, and file types given by flag, and the sdl version checked,
+// but no location yet. If accs isn't nil, it's asked for instead of the accessions given by flag.
+func Resolve(accs []string) (*Client, error) {
+	c := &Client{Tokenpath: flags.FoldNgcIntoToken(flags.Tokenpath, flags.NgcPath), Accessions: accs}
+	var err error
+	if c.Tokenpath != "" {
+		c.Token, err = flags.ResolveNgcFile(c.Tokenpath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.Accessions == nil && flags.Accession != "" {
+		c.Accessions, err = flags.ResolveAccession(flags.Accession)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if flags.Filetype != "" {
+		c.Types, err = flags.ResolveFileType(flags.Filetype)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := sdl.ValidateVersion(flags.SdlVersion); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Locate Resolves the location flags, or the location of the instance if none are given,
+// and sets up the SDL API to ask for c's accessions from there.
+// Location takes longest if there's a failure, so do it after validating everything else.
+func (c *Client) Locate() error {
+	var err error
+	if flags.IdentityTokenFile != "" || flags.IdentityTokenCommand != "" {
+		// Proving location with a workload identity, as from a pod.
+		c.Locator, err = gps.NewWorkloadLocator(flags.Location, flags.IdentityTokenFile, flags.IdentityTokenCommand)
+		if err != nil {
+			twig.Debug(err)
+			return err
+		}
+	} else if flags.Location != "" {
+		c.Locator, err = gps.NewManualLocation(flags.Location)
+		if err != nil {
+			twig.Debug(err)
+			return err
+		}
+	} else { // figure out which locator we'll need
+		gps.AllowImdsV1 = flags.AwsImdsV1
+		c.Locator, err = gps.GenerateLocator()
+		if err != nil {
+			twig.Debug(err)
+			return errors.New("no location provided")
+		}
+	}
+
+	info.LoadAccessionMap(c.Accessions)
+	info.SdlVersion = flags.SdlVersion
+	c.API = sdl.NewSDL()
+	c.API.Param = sdl.NewParam(c.Accessions, c.Locator, c.Token, sdl.SetAcceptCharges(flags.AwsProfile, flags.GcpProfile), c.Types)
+	if flags.Endpoint != "" {
+		c.API.URL = flags.Endpoint
+	}
+	return nil
+}
+
+// Describe Writes what c asks the SDL API for and how, as for the verbose flag.
+func (c *Client) Describe(w io.Writer) {
+	fmt.Fprintf(w, "Communicating with SDL API v%s at: %s\n", info.SdlVersion, c.API.URL)
+	fmt.Fprintf(w, "Using token at: %s\n", c.Tokenpath)
+	fmt.Fprintf(w, "Contents of token: %s\n", string(c.Token[:]))
+	fmt.Fprintf(w, "Limiting file types to: %v\n", c.Types)
+	fmt.Fprintf(w, "Giving locality as: %s\n", c.Locator.LocalityType())
+	fmt.Fprintf(w, "Requesting accessions in batches of: %d\n", flags.Batch)
+}
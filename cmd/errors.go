@@ -20,6 +20,8 @@ import (
 	"strings"
 
 	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
 )
 
 func prettyPrintError(err error) {
@@ -40,7 +42,23 @@ func prettyPrintError(err error) {
 	// Location errors
 	if err.Error() == "no location provided" {
 		twig.Debug(err)
-		fmt.Println("No location provided: A location was not provided so Fusera attempted to resolve the location itself and could not do so. This feature is only supported when Fusera is running on Amazon or Google's cloud platforms. If you are running on a server in either of these two cloud platforms and are still getting this message, run fusera with debug enabled for a more detailed error message and contact your IT administrator with its contents.")
+		fmt.Println("No location provided: A location was not provided so Fusera attempted to resolve the location itself and could not do so. This feature is only supported when Fusera is running on Amazon, Google, or Azure's cloud platforms. If you are running on a server in one of these cloud platforms and are still getting this message, run fusera with debug enabled for a more detailed error message and contact your IT administrator with its contents.")
+	}
+
+	// Manifest errors
+	if _, ok := errors.Cause(err).(*fuseralib.BadManifestError); ok {
+		twig.Debug(err)
+		fmt.Println("Bad manifest file: Fusera couldn't use the manifest file given. Make sure the path leads to a manifest written by the save-manifest flag, that you have permissions to read it, and that it isn't corrupted. " + err.Error())
+	}
+	if strings.Contains(err.Error(), "couldn't write manifest file") {
+		twig.Debug(err)
+		fmt.Println("Couldn't save manifest: Fusera couldn't write the manifest to the path given. Make sure the directory exists and that you have permissions to write to it.")
+	}
+
+	// Local data errors
+	if strings.Contains(err.Error(), "local data") {
+		twig.Debug(err)
+		fmt.Println("Bad local data: Fusera couldn't serve the local data given. Make sure the path leads to a manifest or a directory whose subdirectories are named after accessions and hold their files, and that you have permissions to read them. " + err.Error())
 	}
 
 	// Ngc errors
@@ -55,6 +73,18 @@ func prettyPrintError(err error) {
 		fmt.Println("Filetype was empty: Fusera tried to parse the list of filetypes given but couldn't find anything. Example of a well formatted list to the filetype flag: -f \"bai,crai,cram\".")
 	}
 
+	// SDL version errors
+	if strings.Contains(err.Error(), "unsupported SDL API version") {
+		twig.Debug(err)
+		fmt.Println("Unsupported SDL API version: Fusera doesn't know how to read responses from the version of the SDL API given to the sdl-version flag. " + err.Error())
+	}
+
+	// Price table errors
+	if strings.Contains(err.Error(), "price table") {
+		twig.Debug(err)
+		fmt.Println("Bad price table: Fusera tried to read the price table at the path given to the prices flag and couldn't. Make sure the path leads to a json file like the example in the help for estimate and that you have permissions to read it. " + err.Error())
+	}
+
 	// Mount errors
 	if strings.Contains(err.Error(), "mountpoint doesn't exist") {
 		twig.Debug(err)
@@ -70,6 +100,10 @@ func prettyPrintError(err error) {
 	}
 
 	// API errors
+	if strings.Contains(err.Error(), "expected SDL API version") {
+		twig.Debug(err)
+		fmt.Println("Unexpected SDL API version: The SDL API responded with a different version than the one Fusera asked for. Rather than guess at how to read the response, Fusera stopped. Check that the endpoint and sdl-version flags agree with each other.")
+	}
 	if strings.Contains(err.Error(), "failed to locate accessions") {
 		twig.Debug(err)
 		fmt.Println("Failed to locate accessions: It seems that Fusera has encountered an error while using the SRA Data Locator API to determine the file locations for accessions. This is an issue between Fusera and the API. In order to get more information, run Fusera with debug enabled and contact your IT administrator with its contents.")
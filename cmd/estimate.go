@@ -0,0 +1,88 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/client"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	estimateJSON   bool
+	estimatePrices string
+)
+
+func init() {
+	flags.Register(estimateCmd.Flags(), flags.Common...)
+	estimateCmd.Flags().BoolVarP(&estimateJSON, "json", "", false, "Print the estimate as a json object instead of a table.")
+	estimateCmd.Flags().StringVarP(&estimatePrices, "prices", "", "", "A path to a json price table of what each cloud charges per GB read out of its storage, in-region, cross-region, and cross-cloud. Clouds it leaves out use built in list prices.\nEXAMPLE: {\"currency\": \"USD\", \"clouds\": {\"s3\": {\"inRegion\": 0, \"crossRegion\": 0.02, \"crossCloud\": 0.09}}}")
+
+	rootCmd.AddCommand(estimateCmd)
+}
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [flags]",
+	Short: "Estimate what reading the accessions would cost without mounting them.",
+	Long: `Total the bytes of the accessions by the cloud service and region they're stored in and who pays to read them,
+and estimate what reading them from the location would cost. Only requester pays files are charged to you,
+by how far they travel: in-region, cross-region, or cross-cloud. Only metadata is asked for, so no links are signed.`,
+	Args: cobra.NoArgs,
+	RunE: estimate,
+}
+
+// estimate asks the SDL API for the metadata of each accession and prints what reading them is expected to cost.
+func estimate(cmd *cobra.Command, args []string) (err error) {
+	setConfig()
+	flags.FoldEnvVarsIntoFlagValues(cmd.Flags())
+
+	prices := fuseralib.DefaultPrices
+	if estimatePrices != "" {
+		prices, err = fuseralib.LoadPriceTable(estimatePrices)
+		if err != nil {
+			return err
+		}
+	}
+
+	c, err := client.New(nil)
+	if err != nil {
+		return err
+	}
+	if flags.Verbose {
+		c.Describe(os.Stderr)
+	}
+	region, err := c.Locator.Region()
+	if err != nil {
+		twig.Debug(err)
+		return errors.Wrap(err, "couldn't resolve region")
+	}
+	// The estimate carries each accession's errors, so the SDL API needn't print them too.
+	flags.Silent = true
+	if estimateJSON {
+		// Anything else printed would break the json.
+		flags.Verbose = false
+	}
+	accessions, err := fuseralib.RetrieveAccessions(c.API, c.Accessions, flags.Batch)
+	if err != nil {
+		return err
+	}
+	return fuseralib.WriteEstimate(os.Stdout, fuseralib.NewEstimate(accessions, c.Locator.SdlCloudName(), region, prices), estimateJSON)
+}
@@ -0,0 +1,68 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mitre/fusera/client"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/spf13/cobra"
+)
+
+var lsJSON bool
+
+func init() {
+	flags.Register(lsCmd.Flags(), flags.Common...)
+	lsCmd.Flags().BoolVarP(&lsJSON, "json", "", false, "Print a json array with an object for each file instead of a table.")
+
+	rootCmd.AddCommand(lsCmd)
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [flags]",
+	Short: "List what's in the accessions without mounting them.",
+	Long: `List each file of the accessions with its type, size, md5, the cloud service and region it's in,
+and whether reading it needs payment (PAY) or a compute environment (CE). Only metadata is asked for, so no links are signed.`,
+	Args: cobra.NoArgs,
+	RunE: ls,
+}
+
+// ls asks the SDL API for the metadata of each accession and prints it.
+func ls(cmd *cobra.Command, args []string) (err error) {
+	setConfig()
+	flags.FoldEnvVarsIntoFlagValues(cmd.Flags())
+
+	c, err := client.New(nil)
+	if err != nil {
+		return err
+	}
+	if flags.Verbose {
+		c.Describe(os.Stderr)
+	}
+	// The listing carries each accession's errors, so the SDL API needn't print them too.
+	flags.Silent = true
+	if lsJSON {
+		// Anything else printed would break the json.
+		flags.Verbose = false
+	}
+	accessions, err := fuseralib.RetrieveAccessions(c.API, c.Accessions, flags.Batch)
+	if err != nil {
+		return err
+	}
+	return fuseralib.WriteListing(os.Stdout, accessions, lsJSON)
+}
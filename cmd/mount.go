@@ -25,64 +25,25 @@ import (
 	"os/user"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/mitre/fusera/info"
 
 	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/client"
 	"github.com/mitre/fusera/flags"
 	"github.com/mitre/fusera/fuseralib"
 	"github.com/mitre/fusera/gps"
-	"github.com/mitre/fusera/sdl"
+	"github.com/mitre/fusera/local"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 func init() {
-	mountCmd.Flags().StringVarP(&flags.Location, "location", "l", "", flags.LocationMsg)
-	if err := viper.BindPFlag("location", mountCmd.Flags().Lookup("location")); err != nil {
-		panic("INTERNAL ERROR: could not bind location flag to location environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.Accession, "accession", "a", "", flags.AccessionMsg)
-	if err := viper.BindPFlag("accession", mountCmd.Flags().Lookup("accession")); err != nil {
-		panic("INTERNAL ERROR: could not bind accession flag to accession environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.Tokenpath, "token", "t", "", flags.TokenMsg)
-	if err := viper.BindPFlag("token", mountCmd.Flags().Lookup("token")); err != nil {
-		panic("INTERNAL ERROR: could not bind token flag to token environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.NgcPath, "ngc", "n", "", flags.NgcMsg)
-	if err := viper.BindPFlag("ngc", mountCmd.Flags().Lookup("ngc")); err != nil {
-		panic("INTERNAL ERROR: could not bind ngc flag to ngc environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.Filetype, "filetype", "f", "", flags.FiletypeMsg)
-	if err := viper.BindPFlag("filetype", mountCmd.Flags().Lookup("filetype")); err != nil {
-		panic("INTERNAL ERROR: could not bind filetype flag to filetype environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.Endpoint, "endpoint", "e", "https://www.ncbi.nlm.nih.gov/Traces/sdl/2/retrieve", flags.EndpointMsg)
-	if err := viper.BindPFlag("endpoint", mountCmd.Flags().Lookup("endpoint")); err != nil {
-		panic("INTERNAL ERROR: could not bind endpoint flag to endpoint environment variable")
-	}
-
-	mountCmd.Flags().IntVarP(&flags.Batch, "batch", "", flags.BatchDefault, flags.BatchMsg)
-	if err := viper.BindPFlag("batch", mountCmd.Flags().Lookup("batch")); err != nil {
-		panic("INTERNAL ERROR: could not bind batch flag to batch environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.AwsProfile, "aws-profile", "", "", flags.AwsProfileMsg)
-	if err := viper.BindPFlag("aws-profile", mountCmd.Flags().Lookup("aws-profile")); err != nil {
-		panic("INTERNAL ERROR: could not bind aws-profile flag to aws-profile environment variable")
-	}
-
-	mountCmd.Flags().StringVarP(&flags.GcpProfile, "gcp-profile", "", "", flags.GcpProfileMsg)
-	if err := viper.BindPFlag("gcp-profile", mountCmd.Flags().Lookup("gcp-profile")); err != nil {
-		panic("INTERNAL ERROR: could not bind gcp-profile flag to gcp-profile environment variable")
-	}
+	flags.Register(mountCmd.Flags(), flags.Common...)
+	flags.Register(mountCmd.Flags(), flags.LocalName, flags.ManifestName, flags.SaveManifestName, flags.RegionPolicyName)
+	flags.Register(mountCmd.Flags(), flags.BudgetName, flags.AccessionBudgetName, flags.BudgetPeriodName, flags.BudgetRequesterPaysOnlyName)
+	flags.Register(mountCmd.Flags(), flags.AuditLogName)
 
 	rootCmd.AddCommand(mountCmd)
 }
@@ -98,28 +59,27 @@ var mountCmd = &cobra.Command{
 // and then mounts a FUSE system.
 func mount(cmd *cobra.Command, args []string) (err error) {
 	setConfig()
-	flags.FoldEnvVarsIntoFlagValues()
-	tokenpath := flags.FoldNgcIntoToken(flags.Tokenpath, flags.NgcPath)
-	var token []byte
-	if tokenpath != "" {
-		token, err = flags.ResolveNgcFile(tokenpath)
-		if err != nil {
-			return err
-		}
+	flags.FoldEnvVarsIntoFlagValues(cmd.Flags())
+	c, err := client.Resolve(nil)
+	if err != nil {
+		return err
 	}
-	var accs []string
-	if flags.Accession != "" {
-		accs, err = flags.ResolveAccession(flags.Accession)
-		if err != nil {
-			return err
-		}
+	policy, err := fuseralib.ParseRegionPolicy(flags.RegionPolicy)
+	if err != nil {
+		return err
 	}
-	var types map[string]bool
-	if flags.Filetype != "" {
-		types, err = flags.ResolveFileType(flags.Filetype)
+	budget, err := resolveBudget()
+	if err != nil {
+		return err
+	}
+	// A manifest decides which accessions are mounted.
+	var manifest *fuseralib.Manifest
+	if flags.Manifest != "" {
+		manifest, err = fuseralib.LoadManifest(flags.Manifest, flags.SdlVersion)
 		if err != nil {
 			return err
 		}
+		c.Accessions = manifest.IDs()
 	}
 	// Validate the mount point before trying to mount to it.
 	// So it must exist
@@ -131,41 +91,43 @@ func mount(cmd *cobra.Command, args []string) (err error) {
 	if !flags.HavePermissions(mountpoint) {
 		return errors.New("incorrect permissions for mountpoint")
 	}
-	// Location takes longest if there's a failure, so validate it last.
+	var API fuseralib.API
 	var locator gps.Locator
-	if flags.Location != "" {
-		locator, err = gps.NewManualLocation(flags.Location)
+	if flags.Local != "" {
+		// Local data stands in for the SDL API, so there's no location to resolve.
+		provider, err := local.New(flags.Local)
 		if err != nil {
-			twig.Debug(err)
-			fmt.Println(err)
 			return err
 		}
-	} else { // figure out which locator we'll need
-		locator, err = gps.GenerateLocator()
-		if err != nil {
-			twig.Debug(err)
-			fmt.Println(err)
-			return errors.New("no location provided")
+		provider.Acc = c.Accessions
+		provider.FileType = c.Types
+		API = provider
+		if flags.Verbose {
+			fmt.Printf("Serving local data from: %s\n", flags.Local)
+			fmt.Printf("Limiting file types to: %v\n", c.Types)
+		}
+	} else {
+		if err := c.Locate(); err != nil {
+			return err
+		}
+		locator, API = c.Locator, c.API
+		if flags.Verbose {
+			c.Describe(os.Stdout)
 		}
 	}
-
-	info.LoadAccessionMap(accs)
-	var API = sdl.NewSDL()
-	var param = sdl.NewParam(accs, locator, token, sdl.SetAcceptCharges(flags.AwsProfile, flags.GcpProfile), types)
-	API.Param = param
-	API.URL = flags.Endpoint
-	if flags.Verbose {
-		fmt.Printf("Communicating with SDL API at: %s\n", flags.Endpoint)
-		fmt.Printf("Using token at: %s\n", flags.Tokenpath)
-		fmt.Printf("Contents of token: %s\n", string(token[:]))
-		fmt.Printf("Limiting file types to: %v\n", types)
-		fmt.Printf("Giving locality as: %s\n", locator.LocalityType())
-		fmt.Printf("Requesting accessions in batches of: %d\n", flags.Batch)
-	}
-	accessions, warnings := fuseralib.FetchAccessions(API, accs, flags.Batch)
-	if warnings != nil {
-		if !flags.Silent {
-			fmt.Println(err.Error())
+	var accessions []*fuseralib.Accession
+	if manifest != nil {
+		if flags.Verbose {
+			fmt.Printf("Building file system from manifest at: %s\n", flags.Manifest)
+		}
+		accessions = manifest.List()
+	} else {
+		var warnings error
+		accessions, warnings = fuseralib.FetchAccessions(API, c.Accessions, flags.Batch)
+		if warnings != nil {
+			if !flags.Silent {
+				fmt.Println(warnings.Error())
+			}
 		}
 	}
 	if len(accessions) == 0 {
@@ -174,29 +136,72 @@ func mount(cmd *cobra.Command, args []string) (err error) {
 		}
 		os.Exit(1)
 	}
+	if flags.SaveManifest != "" {
+		if err := fuseralib.SaveManifest(flags.SaveManifest, info.SdlVersion, accessions); err != nil {
+			return err
+		}
+		if flags.Verbose {
+			fmt.Printf("Saved manifest to: %s\n", flags.SaveManifest)
+		}
+	}
 
-	region, err := locator.Region()
-	if err != nil {
-		if !flags.Silent {
-			fmt.Println("It seems like fusera is encountering errors resolving its region, shutting down.")
+	var cloud, region string
+	if locator != nil {
+		cloud = locator.SdlCloudName()
+		region, err = locator.Region()
+		if err != nil {
+			if !flags.Silent {
+				fmt.Println("It seems like fusera is encountering errors resolving its region, shutting down.")
+			}
+			os.Exit(1)
+		}
+	}
+	// Without a location, as with local data, there's no region for files to be outside of.
+	var guard *fuseralib.RegionGuard
+	if locator != nil {
+		guard = fuseralib.NewRegionGuard(policy, cloud, region)
+		byID := make(map[string]*fuseralib.Accession, len(accessions))
+		for _, a := range accessions {
+			byID[a.ID] = a
+		}
+		for _, m := range guard.Filter(accessions) {
+			if policy == fuseralib.RegionDeny {
+				// Left out files are explained in the accession's error.log.
+				byID[m.Accession].AppendError(fmt.Sprintf("%s was left out by the region policy: %s\n", m.File.Name, m.Reason))
+			}
 		}
-		os.Exit(1)
 	}
 
 	if flags.Verbose {
 		fmt.Println("Setting fusera options with:")
-		fmt.Printf("Cloud is: %s\n", locator.SdlCloudName())
+		fmt.Printf("Cloud is: %s\n", cloud)
 		fmt.Printf("Region is: %s\n", region)
+		fmt.Printf("Region policy is: %s\n", policy)
+		fmt.Printf("Budget is: %d bytes, %d bytes per accession, every %s (0 is no cap)\n", budget.Limit, budget.AccessionLimit, budget.Period)
 		fmt.Printf("AWS profile for credentials if needed: %s\n", flags.AwsProfile)
 		fmt.Printf("GCP profile for credentials if needed: %s\n", flags.GcpProfile)
 		fmt.Printf("Mountpoint: %s\n", mountpoint)
 	}
 	uid, gid := myUserAndGroup()
+	var audit *fuseralib.AuditLog
+	if flags.AuditLog != "" {
+		audit, err = fuseralib.OpenAuditLog(flags.AuditLog)
+		if err != nil {
+			return err
+		}
+		defer audit.Close()
+		if flags.Verbose {
+			fmt.Printf("Recording file access to audit log at: %s\n", flags.AuditLog)
+		}
+	}
 	opt := &fuseralib.Options{
 		API:           API,
 		Acc:           accessions,
 		Region:        region,
-		CloudProfile:  flags.SetProfile(locator.SdlCloudName()),
+		CloudProfile:  flags.SetProfile(cloud),
+		RegionGuard:   guard,
+		Budget:        budget,
+		AuditLog:      audit,
 		UID:           uint32(uid),
 		GID:           uint32(gid),
 		MountOptions:  make(map[string]string),
@@ -220,10 +225,36 @@ func mount(cmd *cobra.Command, args []string) (err error) {
 	if err != nil {
 		return errors.Wrap(err, "FATAL")
 	}
+	if flags.Verbose {
+		read, sources := budget.Sources()
+		for _, s := range sources {
+			fmt.Printf("Read %d bytes from %s bucket: %s\n", read[s], s.Service, s.Bucket)
+		}
+	}
 
 	return nil
 }
 
+// resolveBudget Returns the Budget given by the budget flags.
+func resolveBudget() (*fuseralib.Budget, error) {
+	limit, err := flags.ResolveSize(flags.Budget)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse budget")
+	}
+	accessionLimit, err := flags.ResolveSize(flags.AccessionBudget)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse accession budget")
+	}
+	var period time.Duration
+	if flags.BudgetPeriod != "" {
+		period, err = time.ParseDuration(flags.BudgetPeriod)
+		if err != nil || period < 0 {
+			return nil, errors.Errorf("couldn't parse budget period: %s, expected a duration like 24h or 0", flags.BudgetPeriod)
+		}
+	}
+	return fuseralib.NewBudget(limit, accessionLimit, period, flags.BudgetRequesterPaysOnly), nil
+}
+
 func myUserAndGroup() (int, int) {
 	user, err := user.Current()
 	if err != nil {
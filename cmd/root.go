@@ -36,15 +36,7 @@ func init() {
 		panic("INTERNAL ERROR: could not bind debug flag to debug environment variable")
 	}
 
-	rootCmd.PersistentFlags().BoolVarP(&flags.Silent, "silent", "s", false, flags.SilentMsg)
-	if err := viper.BindPFlag("silent", mountCmd.Flags().Lookup("silent")); err != nil {
-		panic("INTERNAL ERROR: could not bind silent flag to silent environment variable")
-	}
-
-	rootCmd.PersistentFlags().BoolVarP(&flags.Verbose, "verbose", "v", false, flags.VerboseMsg)
-	if err := viper.BindPFlag("verbose", mountCmd.Flags().Lookup("verbose")); err != nil {
-		panic("INTERNAL ERROR: could not bind verbose flag to verbose environment variable")
-	}
+	flags.Register(rootCmd.PersistentFlags(), flags.Output...)
 
 	viper.SetEnvPrefix(flags.EnvPrefix)
 	viper.AutomaticEnv()
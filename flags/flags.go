@@ -2,26 +2,30 @@ package flags
 
 import (
 	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/mitre/fusera/awsutil"
 	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var (
 	EnvPrefix = "dbgap"
 
-	LocationName  = "location"
-	AccessionName = "accession"
-	NgcName       = "ngc"
-	TokenName     = "token"
-	FiletypeName  = "filetype"
-	EndpointName  = "endpoint"
-	BatchName     = "batch"
-	SilentName    = "silent"
-	VerboseName   = "verbose"
+	LocationName   = "location"
+	AccessionName  = "accession"
+	NgcName        = "ngc"
+	TokenName      = "token"
+	FiletypeName   = "filetype"
+	EndpointName   = "endpoint"
+	SdlVersionName = "sdl-version"
+	BatchName      = "batch"
+	SilentName     = "silent"
+	VerboseName    = "verbose"
 
 	Silent  bool
 	Verbose bool
@@ -33,22 +37,108 @@ var (
 	Filetype  string
 
 	Endpoint            string
+	SdlVersion          string
 	Batch, BatchDefault int = 0, 50
 	AwsProfile          string
 	GcpProfile          string
+	AwsImdsV1           bool
 
-	LocationMsg   = "Fusera can resolve location when executed inside AWS or GCP environments, otherwise a location will need to be provided and errors in location might result in undesired outcomes.\nFORMAT: [cloud.region]\nEXAMPLES: [s3.us-east-1 | gs.US]\nEnvironment Variable: [$DBGAP_LOCATION]"
+	IdentityTokenFile    string
+	IdentityTokenCommand string
+
+	RegionPolicy string
+
+	BudgetName                  = "budget"
+	AccessionBudgetName         = "accession-budget"
+	BudgetPeriodName            = "budget-period"
+	BudgetRequesterPaysOnlyName = "budget-requester-pays-only"
+	Budget                      string
+	AccessionBudget             string
+	BudgetPeriod                string
+	BudgetRequesterPaysOnly     bool
+
+	AuditLogName = "audit-log"
+	AuditLog     string
+
+	ManifestName     = "manifest"
+	SaveManifestName = "save-manifest"
+	Manifest         string
+	SaveManifest     string
+
+	LocalName = "local"
+	Local     string
+
+	RetriesName     = "retries"
+	ConcurrencyName = "concurrency"
+	Retries         int
+	Concurrency     int
+
+	LimitRateName   = "limit-rate"
+	ConnectionsName = "connections"
+	ChunkSizeName   = "chunk-size"
+	LimitRate       string
+	Connections     int
+	ChunkSize       string
+
+	OrderName             = "order"
+	LayoutName            = "layout"
+	Order                 string
+	Layout, LayoutDefault string = "", "{accession}/{name}"
+
+	DryRunName  = "dry-run"
+	ListName    = "list"
+	JSONName    = "json"
+	SummaryName = "summary"
+	DryRun      bool
+	List        bool
+	JSON        bool
+	Summary     string
+
+	LocationMsg   = "Fusera can resolve location when executed inside AWS, GCP, or Azure environments, otherwise a location will need to be provided and errors in location might result in undesired outcomes.\nFORMAT: [cloud.region]\nEXAMPLES: [s3.us-east-1 | gs.US | azure.eastus]\nEnvironment Variable: [$DBGAP_LOCATION]"
 	AccessionMsg  = "A list of accessions to mount or path to accession file.\nEXAMPLES: [\"SRR123,SRR456\" | local/accession/file | https://<bucket>.<region>.s3.amazonaws.com/<accession/file>]\nNOTE: If using an s3 url, the proper aws credentials need to be in place on the machine.\nEnvironment Variable: [$DBGAP_ACCESSION]"
 	NgcMsg        = "A path to an ngc file used to authorize access to accessions in dbGaP. If used in tandem with token, the token takes precedence.\nEXAMPLES: [local/ngc/file | https://<bucket>.<region>.s3.amazonaws.com/<ngc/file>]\nNOTE: If using an s3 url, the proper aws credentials need to be in place on the machine.\nEnvironment Variable: [$DBGAP_NGC]"
 	TokenMsg      = "A path to one of the various security tokens used to authorize access to accessions in dbGaP.\nEXAMPLES: [local/token/file | https://<bucket>.<region>.s3.amazonaws.com/<token/file>]\nNOTE: If using an s3 url, the proper aws credentials need to be in place on the machine.\nEnvironment Variable: [$DBGAP_TOKEN]"
 	FiletypeMsg   = "A list of the only file types to copy.\nEXAMPLES: \"cram,crai,bam,bai\"\nEnvironment Variable: [$DBGAP_FILETYPE]"
-	EndpointMsg   = "ADVANCED: Change the endpoint used to communicate with SDL API.\nEnvironment Variable: [$DBGAP_ENDPOINT]"
+	EndpointMsg   = "ADVANCED: Change the endpoint used to communicate with SDL API. Defaults to the NCBI endpoint for the version of the SDL API given by sdl-version.\nEnvironment Variable: [$DBGAP_ENDPOINT]"
+	SdlVersionMsg = "ADVANCED: The version of the SDL API to request and expect responses from.\nEXAMPLES: [1 | 2 | 3]\nEnvironment Variable: [$DBGAP_SDL-VERSION]"
 	BatchMsg      = "ADVANCED: Adjust the amount of accessions put in one request to the SDL API.\nEnvironment Variable: [$DBGAP_BATCH]"
 	GcpBatchMsg   = "ADVANCED: Adjust the amount of accessions put in one request to the SDL API when using a GCP location.\nEnvironment Variable: [$DBGAP_GCP-BATCH]"
 	AwsProfileMsg = "The desired AWS credentials profile in ~/.aws/credentials to use for instances when files require the requester (you) to pay for accessing the file.\nEnvironment Variable: [$DBGAP_AWS-PROFILE]\nNOTE: This account will be charged all cost accrued by accessing these certain files."
 	GcpProfileMsg = "The desired GCP credentials profile in ~/.aws/credentials to use for instances when files require the requester (you) to pay for accessing the file.\nEnvironment Variable: [$DBGAP_GCP-PROFILE]\nNOTE: This account will be charged all cost accrued by accessing these certain files. These credentials should be in the AWS supported format that Google provides in order to work with their AWS compatible API."
+	AwsImdsV1Msg  = "ADVANCED: When resolving location on AWS, fall back to IMDSv1 if the instance metadata service won't give out an IMDSv2 session token. Instances that require IMDSv2 refuse IMDSv1, so this is only needed on old or unusual setups.\nEnvironment Variable: [$DBGAP_AWS-IMDSV1]"
 	SilentMsg     = "Prints nothing, most useful when running in scripts."
 	VerboseMsg    = "Prints everything, most useful for troubleshooting."
+
+	IdentityTokenFileMsg    = "A path to a workload identity token issued by google to prove location with instead of the instance's identity, such as the one GKE workload identity gives a pod. It's read again whenever it's needed, so rotated tokens are picked up. Requires a location on gs.\nEnvironment Variable: [$DBGAP_IDENTITY-TOKEN-FILE]"
+	IdentityTokenCommandMsg = "A command, run with sh, that prints a workload identity token issued by google to prove location with instead of the instance's identity. The token is reused until it expires. Requires a location on gs.\nEnvironment Variable: [$DBGAP_IDENTITY-TOKEN-COMMAND]"
+
+	BudgetMsg                  = "Cap how many bytes the mount reads in each budget period, such as 500G. Reads that would go over fail with a disk quota exceeded error until the next period. Accepts suffixes K, M, G, and T, which are powers of 1024. What counts is the bytes handed to programs reading files, so a little more may be downloaded: data already on its way when a program skips elsewhere in a file is thrown away uncounted.\nEnvironment Variable: [$DBGAP_BUDGET]"
+	AccessionBudgetMsg         = "Cap how many bytes the mount reads from each accession in each budget period, such as 50G. Accepts suffixes K, M, G, and T.\nEnvironment Variable: [$DBGAP_ACCESSION-BUDGET]"
+	BudgetPeriodMsg            = "How long each budget period lasts before what's been read is forgotten, such as 24h. 0 makes the budget last as long as the mount.\nEnvironment Variable: [$DBGAP_BUDGET-PERIOD]"
+	BudgetRequesterPaysOnlyMsg = "Count only requester pays files, which you're charged to read, against the budget.\nEnvironment Variable: [$DBGAP_BUDGET-REQUESTER-PAYS-ONLY]"
+
+	AuditLogMsg = "A path to append a record of every file opened and closed to, as a json object per line with the time, uid and pid of the process opening the file, accession, file name, bytes read, and result. The file is created if it doesn't exist. Opens that can't be recorded are refused.\nEnvironment Variable: [$DBGAP_AUDIT-LOG]"
+
+	RegionPolicyMsg = "What to do about files stored in a different cloud or region from the location, which cost egress charges to read. warn reads them but says so, deny leaves them out and refuses to read them, allow reads them quietly.\nEXAMPLES: [warn | deny | allow]\nEnvironment Variable: [$DBGAP_REGION-POLICY]"
+
+	ManifestMsg     = "A path to a manifest file written by save-manifest. The file system is built from the manifest instead of asking the SDL API, links are signed again as they expire. It must have been saved with the same sdl-version.\nEnvironment Variable: [$DBGAP_MANIFEST]"
+	LocalMsg        = "DEVELOPMENT: A path to a directory of local files or a manifest to serve instead of asking the SDL API. In a directory, each subdirectory is presented as an accession containing its files. No location or credentials are needed.\nEnvironment Variable: [$DBGAP_LOCAL]"
+	SaveManifestMsg = "A path to write a manifest of the accessions resolved by the SDL API to, for fast restarts with manifest and as a record of what was mounted. Only you can read it, since it holds signed links.\nEnvironment Variable: [$DBGAP_SAVE-MANIFEST]"
+
+	RetriesMsg     = "How many times to try a download again when it's interrupted or doesn't match the size or md5 given by the SDL API.\nEnvironment Variable: [$DBGAP_RETRIES]"
+	ConcurrencyMsg = "How many files to download at once, across all accessions.\nEnvironment Variable: [$DBGAP_CONCURRENCY]"
+
+	LimitRateMsg   = "Cap the combined bandwidth of all downloads, in bytes per second. Accepts suffixes K, M, and G, as in 500K or 10M.\nEnvironment Variable: [$DBGAP_LIMIT-RATE]"
+	ConnectionsMsg = "How many connections to download each large file over, a chunk at a time. 1 downloads every file over a single connection.\nEnvironment Variable: [$DBGAP_CONNECTIONS]"
+	ChunkSizeMsg   = "How big each chunk of a large file is. Files no bigger than this are downloaded over a single connection. Accepts suffixes K, M, and G.\nEnvironment Variable: [$DBGAP_CHUNK-SIZE]"
+
+	OrderMsg  = "The order to download files in: smallest or largest first, or cart to download them in the order the accessions were given.\nEXAMPLES: [smallest | largest | cart]\nEnvironment Variable: [$DBGAP_ORDER]"
+	LayoutMsg = "Where to put each file under the destination. Placeholders {accession}, {name}, {type}, and {md5} are filled in for each file, and {placeholder:n} keeps only the first n characters.\nEXAMPLES: [{md5:2}/{md5}_{name} | {type}/{accession}_{name}]\nEnvironment Variable: [$DBGAP_LAYOUT]"
+
+	DryRunMsg  = "List the files that would be downloaded, how much of each is left, and the total size, then exit without downloading anything.\nEnvironment Variable: [$DBGAP_DRY-RUN]"
+	ListMsg    = "List each file of the accessions with its type, size, md5, service, region, and whether it needs payment or a compute environment, then exit without downloading anything. No destination is needed.\nEnvironment Variable: [$DBGAP_LIST]"
+	JSONMsg    = "With list, print a json array with an object for each file instead of a table.\nEnvironment Variable: [$DBGAP_JSON]"
+	SummaryMsg = "Write a json summary of every accession and file, with its status, size, md5, and how long it took, to this path when finished.\nEnvironment Variable: [$DBGAP_SUMMARY]"
 )
 
 // ResolveAccession If a list of comma separated accessions was provided, use it.
@@ -131,6 +221,33 @@ func ResolveFileType(filetype string) (map[string]bool, error) {
 	return nil, errors.New("filetype was empty")
 }
 
+// ResolveSize Parses a number of bytes, such as 64M or 1G. Suffixes are powers of 1024.
+// An empty size is 0.
+func ResolveSize(size string) (uint64, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(size)), "B")
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := uint64(1)
+	if i := strings.IndexAny(s, "KMGT"); i == len(s)-1 {
+		for _, unit := range "KMGT" {
+			multiplier *= 1024
+			if rune(s[i]) == unit {
+				break
+			}
+		}
+		s = s[:i]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("couldn't parse size: %s, expected a number of bytes like 64M or 1G", size)
+	}
+	if n > math.MaxUint64/multiplier {
+		return 0, errors.Errorf("size: %s is too large, it can be at most %d bytes", size, uint64(math.MaxUint64))
+	}
+	return n * multiplier, nil
+}
+
 func NoFileErrors(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -180,16 +297,64 @@ func FoldNgcIntoToken(token, ngc string) string {
 	return token
 }
 
-func FoldEnvVarsIntoFlagValues() {
-	ResolveString("endpoint", &Endpoint)
-	ResolveInt("batch", &Batch)
-	ResolveString("aws-profile", &AwsProfile)
-	ResolveString("gcp-profile", &GcpProfile)
-	ResolveString("location", &Location)
-	ResolveString("accession", &Accession)
-	ResolveString("token", &Tokenpath)
-	ResolveString("ngc", &NgcPath)
-	ResolveString("filetype", &Filetype)
+// FoldEnvVarsIntoFlagValues Sets each flag that wasn't given to the running command, whose flags are fs,
+// from its environment variable if that's set. Flags given on the command line win over the environment.
+func FoldEnvVarsIntoFlagValues(fs *pflag.FlagSet) {
+	given := func(name string) bool {
+		f := fs.Lookup(name)
+		return f != nil && f.Changed
+	}
+	for name, value := range map[string]*string{
+		EndpointName:             &Endpoint,
+		SdlVersionName:           &SdlVersion,
+		AwsProfileName:           &AwsProfile,
+		GcpProfileName:           &GcpProfile,
+		LocationName:             &Location,
+		AccessionName:            &Accession,
+		TokenName:                &Tokenpath,
+		NgcName:                  &NgcPath,
+		FiletypeName:             &Filetype,
+		ManifestName:             &Manifest,
+		SaveManifestName:         &SaveManifest,
+		LocalName:                &Local,
+		IdentityTokenFileName:    &IdentityTokenFile,
+		IdentityTokenCommandName: &IdentityTokenCommand,
+		RegionPolicyName:         &RegionPolicy,
+		BudgetName:               &Budget,
+		AccessionBudgetName:      &AccessionBudget,
+		BudgetPeriodName:         &BudgetPeriod,
+		AuditLogName:             &AuditLog,
+		LimitRateName:            &LimitRate,
+		ChunkSizeName:            &ChunkSize,
+		OrderName:                &Order,
+		LayoutName:               &Layout,
+		SummaryName:              &Summary,
+	} {
+		if !given(name) {
+			ResolveString(name, value)
+		}
+	}
+	for name, value := range map[string]*int{
+		BatchName:       &Batch,
+		RetriesName:     &Retries,
+		ConcurrencyName: &Concurrency,
+		ConnectionsName: &Connections,
+	} {
+		if !given(name) {
+			ResolveInt(name, value)
+		}
+	}
+	for name, value := range map[string]*bool{
+		AwsImdsV1Name:               &AwsImdsV1,
+		BudgetRequesterPaysOnlyName: &BudgetRequesterPaysOnly,
+		DryRunName:                  &DryRun,
+		ListName:                    &List,
+		JSONName:                    &JSON,
+	} {
+		if !given(name) {
+			ResolveBool(name, value)
+		}
+	}
 }
 
 func ResolveString(name string, value *string) {
@@ -0,0 +1,146 @@
+package flags
+
+import (
+	"github.com/mitre/fusera/info"
+	"github.com/spf13/pflag"
+)
+
+var (
+	AwsProfileName = "aws-profile"
+	GcpProfileName = "gcp-profile"
+	AwsImdsV1Name  = "aws-imdsv1"
+
+	IdentityTokenFileName    = "identity-token-file"
+	IdentityTokenCommandName = "identity-token-command"
+
+	RegionPolicyName = "region-policy"
+
+	// Common The flags for talking to the SDL API, which every command that asks it for accessions takes.
+	// Adding an option here adds it to both fusera and sracp.
+	Common = []string{LocationName, AccessionName, TokenName, NgcName, FiletypeName, EndpointName, SdlVersionName, BatchName, AwsProfileName, GcpProfileName, AwsImdsV1Name, IdentityTokenFileName, IdentityTokenCommandName}
+	// Output The flags for how much a command prints, which every command takes.
+	Output = []string{SilentName, VerboseName}
+)
+
+// definitions How to add each shared flag to a flag set, so both binaries
+// give it the same shorthand, default, and help message.
+var definitions = map[string]func(fs *pflag.FlagSet){
+	LocationName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Location, LocationName, "l", "", LocationMsg)
+	},
+	AccessionName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Accession, AccessionName, "a", "", AccessionMsg)
+	},
+	TokenName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Tokenpath, TokenName, "t", "", TokenMsg)
+	},
+	NgcName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&NgcPath, NgcName, "n", "", NgcMsg)
+	},
+	FiletypeName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Filetype, FiletypeName, "f", "", FiletypeMsg)
+	},
+	EndpointName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Endpoint, EndpointName, "e", "", EndpointMsg)
+	},
+	SdlVersionName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&SdlVersion, SdlVersionName, "", info.SdlVersion, SdlVersionMsg)
+	},
+	BatchName: func(fs *pflag.FlagSet) {
+		fs.IntVarP(&Batch, BatchName, "", BatchDefault, BatchMsg)
+	},
+	AwsProfileName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&AwsProfile, AwsProfileName, "", "", AwsProfileMsg)
+	},
+	GcpProfileName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&GcpProfile, GcpProfileName, "", "", GcpProfileMsg)
+	},
+	AwsImdsV1Name: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&AwsImdsV1, AwsImdsV1Name, "", false, AwsImdsV1Msg)
+	},
+	IdentityTokenFileName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&IdentityTokenFile, IdentityTokenFileName, "", "", IdentityTokenFileMsg)
+	},
+	IdentityTokenCommandName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&IdentityTokenCommand, IdentityTokenCommandName, "", "", IdentityTokenCommandMsg)
+	},
+	BudgetName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Budget, BudgetName, "", "", BudgetMsg)
+	},
+	AccessionBudgetName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&AccessionBudget, AccessionBudgetName, "", "", AccessionBudgetMsg)
+	},
+	BudgetPeriodName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&BudgetPeriod, BudgetPeriodName, "", "24h", BudgetPeriodMsg)
+	},
+	BudgetRequesterPaysOnlyName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&BudgetRequesterPaysOnly, BudgetRequesterPaysOnlyName, "", false, BudgetRequesterPaysOnlyMsg)
+	},
+	AuditLogName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&AuditLog, AuditLogName, "", "", AuditLogMsg)
+	},
+	RegionPolicyName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&RegionPolicy, RegionPolicyName, "", "warn", RegionPolicyMsg)
+	},
+	SilentName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&Silent, SilentName, "s", false, SilentMsg)
+	},
+	VerboseName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&Verbose, VerboseName, "v", false, VerboseMsg)
+	},
+	LocalName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Local, LocalName, "", "", LocalMsg)
+	},
+	ManifestName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Manifest, ManifestName, "", "", ManifestMsg)
+	},
+	SaveManifestName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&SaveManifest, SaveManifestName, "", "", SaveManifestMsg)
+	},
+	RetriesName: func(fs *pflag.FlagSet) {
+		fs.IntVarP(&Retries, RetriesName, "", 3, RetriesMsg)
+	},
+	ConcurrencyName: func(fs *pflag.FlagSet) {
+		fs.IntVarP(&Concurrency, ConcurrencyName, "", 4, ConcurrencyMsg)
+	},
+	LimitRateName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&LimitRate, LimitRateName, "", "", LimitRateMsg)
+	},
+	ConnectionsName: func(fs *pflag.FlagSet) {
+		fs.IntVarP(&Connections, ConnectionsName, "", 4, ConnectionsMsg)
+	},
+	ChunkSizeName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&ChunkSize, ChunkSizeName, "", "64M", ChunkSizeMsg)
+	},
+	OrderName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Order, OrderName, "", "smallest", OrderMsg)
+	},
+	LayoutName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Layout, LayoutName, "", LayoutDefault, LayoutMsg)
+	},
+	DryRunName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&DryRun, DryRunName, "", false, DryRunMsg)
+	},
+	ListName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&List, ListName, "", false, ListMsg)
+	},
+	JSONName: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&JSON, JSONName, "", false, JSONMsg)
+	},
+	SummaryName: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&Summary, SummaryName, "", "", SummaryMsg)
+	},
+}
+
+// Register Adds each of the named flags to fs.
+// Their environment variables are read by FoldEnvVarsIntoFlagValues, not bound here,
+// since viper can only bind a name to one command's flag.
+func Register(fs *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		define, ok := definitions[name]
+		if !ok {
+			panic("INTERNAL ERROR: there is no shared flag named " + name)
+		}
+		define(fs)
+	}
+}
@@ -1,6 +1,14 @@
 package fuseralib
 
-import "time"
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 type Accession struct {
 	ID       string `json:"accession,omitempty"`
@@ -35,3 +43,17 @@ type File struct {
 	PayRequired    bool      `json:"payRequired,omitempty"`
 	CeRequired     bool      `json:"ceRequired,omitempty"`
 }
+
+// Md5File Returns the md5 of the file at path as hex, the way the SDL API gives Md5Hash.
+func Md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't open file: %s", path)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "couldn't read file: %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
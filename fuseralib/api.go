@@ -4,12 +4,19 @@ package fuseralib
 type API interface {
 	Retrieve(accession string) (*Accession, error)
 	RetrieveAll() ([]*Accession, error)
+	RetrieveAllInBatch(batch int) ([]*Accession, error)
 	Sign(accession string) (*Accession, error)
 	SignAll() ([]*Accession, error)
 	SignAllInBatch(batch int) ([]*Accession, error)
 	AddIdent(link string) (string, error)
 }
 
+// LocalSource An API whose files are on this machine. Only its file:// links are read,
+// so links from anywhere else can't expose local files through the file system.
+type LocalSource interface {
+	ServesLocalFiles() bool
+}
+
 // FetchAccessions A convenience function to serve the specific behavior of first calling the SDL API on start up.
 func FetchAccessions(api API, accessions []string, batch int) ([]*Accession, error) {
 	if accessions == nil || len(accessions) == 0 { // We have no accessions, but they might be in the token. Alas, no batching can be done.
@@ -17,3 +24,11 @@ func FetchAccessions(api API, accessions []string, batch int) ([]*Accession, err
 	}
 	return api.SignAllInBatch(batch)
 }
+
+// RetrieveAccessions Asks for the metadata of accessions the way FetchAccessions asks for signed links, in batches when they're listed.
+func RetrieveAccessions(api API, accessions []string, batch int) ([]*Accession, error) {
+	if len(accessions) == 0 {
+		return api.RetrieveAll()
+	}
+	return api.RetrieveAllInBatch(batch)
+}
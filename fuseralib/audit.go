@@ -0,0 +1,125 @@
+package fuseralib
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/pkg/errors"
+)
+
+// The events an AuditLog records.
+const (
+	AuditOpen  = "open"
+	AuditClose = "close"
+)
+
+// AuditRecord A line of an AuditLog: a file of an accession being opened or closed.
+type AuditRecord struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	// UID The user of the process that opened the file.
+	UID uint32 `json:"uid"`
+	// PID The process that opened the file.
+	PID       uint32 `json:"pid"`
+	Accession string `json:"accession"`
+	Name      string `json:"name"`
+	// Opened When the file was opened, on close records.
+	Opened *time.Time `json:"opened,omitempty"`
+	// BytesRead How many bytes were read from the file while it was open, on close records.
+	BytesRead uint64 `json:"bytesRead"`
+	// Result "ok", or the error opening or reading the file ran into.
+	Result string `json:"result"`
+}
+
+// AuditLog An append-only log of the files opened on a mount, written as a JSON object per line.
+// A nil AuditLog records nothing.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog Opens the audit log at path, creating it if it doesn't exist.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't open audit log: %s", path)
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// RecordOpen Records that name, a file of acc, was opened by process pid of user uid, with the error opening it ran into if any.
+// An error is returned if the record couldn't be written, so the file can be kept closed rather than read unrecorded.
+func (l *AuditLog) RecordOpen(uid, pid uint32, acc, name string, openErr error) error {
+	if l == nil {
+		return nil
+	}
+	return l.write(AuditRecord{
+		Time:      time.Now(),
+		Event:     AuditOpen,
+		UID:       uid,
+		PID:       pid,
+		Accession: acc,
+		Name:      name,
+		Result:    result(openErr),
+	})
+}
+
+// RecordClose Records that name, a file of acc opened at opened by process pid of user uid, was closed after bytesRead bytes
+// were read from it, with the last error reading it ran into if any. Records that can't be written are logged.
+func (l *AuditLog) RecordClose(uid, pid uint32, acc, name string, opened time.Time, bytesRead uint64, readErr error) {
+	if l == nil {
+		return
+	}
+	err := l.write(AuditRecord{
+		Time:      time.Now(),
+		Event:     AuditClose,
+		UID:       uid,
+		PID:       pid,
+		Accession: acc,
+		Name:      name,
+		Opened:    &opened,
+		BytesRead: bytesRead,
+		Result:    result(readErr),
+	})
+	if err != nil {
+		twig.Infof("%s", err.Error())
+	}
+}
+
+// write Appends rec to the log as a line, syncing it to disk before returning.
+func (l *AuditLog) write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode audit record")
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil {
+		return errors.Wrapf(err, "couldn't write to audit log: %s", l.file.Name())
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrapf(err, "couldn't sync audit log: %s", l.file.Name())
+	}
+	return nil
+}
+
+// Close Closes the log's file.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func result(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
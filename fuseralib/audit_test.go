@@ -0,0 +1,136 @@
+package fuseralib_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/mitre/fusera/fuseralib"
+)
+
+// records Returns the records written to the audit log at path.
+func records(t *testing.T, path string) []fuseralib.AuditRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var rr []fuseralib.AuditRecord
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		var r fuseralib.AuditRecord
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			t.Fatalf("line %q isn't a json record: %v", lines.Text(), err)
+		}
+		rr = append(rr, r)
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestAuditLogRecordsOpenerOfEachFile(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := fuseralib.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	fs := newFusera(t, &fuseralib.Options{API: api, AuditLog: log})
+
+	handle := open(t, fs, fuseops.OpContext{Uid: 1000, Pid: 4242})
+	if _, err := read(fs, handle, 0, 4096); err != nil {
+		t.Fatalf("couldn't read a.cram: %v", err)
+	}
+	if err := fs.ReleaseFileHandle(context.Background(), &fuseops.ReleaseFileHandleOp{Handle: handle}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := records(t, path)
+	if len(rr) != 2 {
+		t.Fatalf("records = %+v, want an open and a close", rr)
+	}
+	for _, r := range rr {
+		if r.UID != 1000 || r.PID != 4242 {
+			t.Errorf("%s record has uid %d and pid %d, want the opener's 1000 and 4242", r.Event, r.UID, r.PID)
+		}
+		if r.Accession != "SRR1" || r.Name != "a.cram" || r.Result != "ok" {
+			t.Errorf("%s record = %+v, want SRR1/a.cram ok", r.Event, r)
+		}
+	}
+	if rr[0].Event != fuseralib.AuditOpen || rr[0].Opened != nil {
+		t.Errorf("first record = %+v, want an open", rr[0])
+	}
+	if rr[1].Event != fuseralib.AuditClose || rr[1].Opened == nil || rr[1].BytesRead != 4096 {
+		t.Errorf("second record = %+v, want a close after 4096 bytes were read", rr[1])
+	}
+}
+
+func TestAuditLogRecordsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := fuseralib.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.RecordOpen(7, 8, "SRR1", "a.cram", syscall.EACCES); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+	// Appended to, not truncated, when opened again.
+	log, err = fuseralib.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.RecordOpen(9, 10, "SRR2", "b.bam", nil); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	rr := records(t, path)
+	if len(rr) != 2 {
+		t.Fatalf("records = %+v, want both opens", rr)
+	}
+	if rr[0].Result != syscall.EACCES.Error() || rr[0].UID != 7 || rr[0].PID != 8 {
+		t.Errorf("first record = %+v, want uid 7 and pid 8 refused with %q", rr[0], syscall.EACCES.Error())
+	}
+	if rr[1].Accession != "SRR2" || rr[1].Result != "ok" {
+		t.Errorf("second record = %+v, want SRR2 ok", rr[1])
+	}
+}
+
+func TestOpenThatCantBeRecordedIsRefused(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	log, err := fuseralib.OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := newFusera(t, &fuseralib.Options{API: api, AuditLog: log})
+	log.Close()
+
+	op := &fuseops.OpenFileOp{Inode: lookUp(t, fs)}
+	if err := fs.OpenFile(context.Background(), op); err != syscall.EIO {
+		t.Errorf("err = %v, want EIO since the open couldn't be recorded", err)
+	}
+}
+
+func TestNilAuditLogRecordsNothing(t *testing.T) {
+	var log *fuseralib.AuditLog
+	if err := log.RecordOpen(1, 2, "SRR1", "a.cram", nil); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	log.RecordClose(1, 2, "SRR1", "a.cram", time.Now(), 0, nil)
+	if err := log.Close(); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
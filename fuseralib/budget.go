@@ -0,0 +1,162 @@
+package fuseralib
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+)
+
+// Budget Caps how many bytes a mount reads in each period, in total and from each accession,
+// and keeps count of the bytes read from each service and bucket.
+// Bytes are counted as they're handed to the kernel, not as they arrive over the network, so what a
+// stream had already been sent when it's closed for a read elsewhere in the file isn't counted.
+// A nil Budget allows everything and counts nothing.
+type Budget struct {
+	// Limit The most bytes read in a period, 0 for no cap.
+	Limit uint64
+	// AccessionLimit The most bytes read from each accession in a period, 0 for no cap.
+	AccessionLimit uint64
+	// Period How long before the bytes read are forgotten, 0 to never forget them.
+	Period time.Duration
+	// RequesterPaysOnly Counts only requester pays files against the limits.
+	RequesterPaysOnly bool
+
+	mu    sync.Mutex
+	start time.Time
+	// period Counts the periods rolled over, so bytes reserved in one aren't given back in another.
+	period     uint64
+	total      uint64
+	accessions map[string]uint64
+	// logged The limits already logged as reached this period, so a refused read isn't logged over and over.
+	logged map[string]bool
+	// sources Bytes read from each bucket since the mount, whether they count against the limits or not.
+	sources map[Source]uint64
+}
+
+// NewBudget Returns a Budget of limit bytes in total and accessionLimit bytes per accession in every period.
+func NewBudget(limit, accessionLimit uint64, period time.Duration, requesterPaysOnly bool) *Budget {
+	return &Budget{
+		Limit:             limit,
+		AccessionLimit:    accessionLimit,
+		Period:            period,
+		RequesterPaysOnly: requesterPaysOnly,
+		start:             time.Now(),
+		accessions:        make(map[string]uint64),
+		logged:            make(map[string]bool),
+		sources:           make(map[Source]uint64),
+	}
+}
+
+// roll Starts a new period if the current one is over. LOCKS_REQUIRED(b.mu)
+func (b *Budget) roll(now time.Time) {
+	if b.Period <= 0 || now.Sub(b.start) < b.Period {
+		return
+	}
+	b.start = now
+	b.period++
+	b.total = 0
+	b.accessions = make(map[string]uint64)
+	b.logged = make(map[string]bool)
+}
+
+// Reservation Bytes of a file of an accession set aside in a Budget before they're read.
+type Reservation struct {
+	acc     string
+	n       uint64
+	period  uint64
+	counted bool
+}
+
+// Reserve Sets aside n bytes to read from a file of acc, or returns EDQUOT if they would go over budget,
+// logging which limit would be gone over the first time it is in a period.
+// Checking and setting aside happen together, so reads made at the same time can't go over budget between them.
+// A read is refused whole rather than cut short, since the kernel takes a short read for the end of the file.
+func (b *Budget) Reserve(acc string, payRequired bool, n uint64) (Reservation, error) {
+	r := Reservation{acc: acc, n: n}
+	if b == nil || (b.RequesterPaysOnly && !payRequired) {
+		return r, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll(time.Now())
+	if b.Limit > 0 && b.total+n > b.Limit {
+		b.log("", "budget of %d bytes reached, refusing to read %s until %s", b.Limit, acc)
+		return r, syscall.EDQUOT
+	}
+	if b.AccessionLimit > 0 && b.accessions[acc]+n > b.AccessionLimit {
+		b.log(acc, "budget of %d bytes for accession %s reached, refusing to read it until %s", b.AccessionLimit, acc)
+		return r, syscall.EDQUOT
+	}
+	b.total += n
+	b.accessions[acc] += n
+	r.period = b.period
+	r.counted = true
+	return r, nil
+}
+
+// log Logs that the limit on acc, or the total if acc is "", was reached, unless it already has been this period.
+// LOCKS_REQUIRED(b.mu)
+func (b *Budget) log(acc, format string, limit uint64, name string) {
+	if b.logged[acc] {
+		return
+	}
+	b.logged[acc] = true
+	until := "the mount is restarted"
+	if b.Period > 0 {
+		until = b.start.Add(b.Period).Format(time.RFC3339)
+	}
+	twig.Infof(format, limit, name, until)
+}
+
+// Settle Counts n bytes of r as read from bucket of service, giving back the rest of r.
+// Bytes reserved in a period that's since ended aren't given back, since that period's count is gone.
+func (b *Budget) Settle(r Reservation, service, bucket string, n int) {
+	if b == nil {
+		return
+	}
+	read := uint64(0)
+	if n > 0 {
+		read = uint64(n)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if read > 0 {
+		b.sources[Source{Service: service, Bucket: bucket}] += read
+	}
+	if !r.counted || r.period != b.period || read >= r.n {
+		return
+	}
+	b.total -= r.n - read
+	b.accessions[r.acc] -= r.n - read
+}
+
+// Source A bucket of a service that files are read from.
+type Source struct {
+	Service string
+	Bucket  string
+}
+
+// Sources Returns the bytes read from each bucket since the mount, and the buckets sorted by service then bucket.
+func (b *Budget) Sources() (map[Source]uint64, []Source) {
+	if b == nil {
+		return nil, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	read := make(map[Source]uint64, len(b.sources))
+	sources := make([]Source, 0, len(b.sources))
+	for s, n := range b.sources {
+		read[s] = n
+		sources = append(sources, s)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Service != sources[j].Service {
+			return sources[i].Service < sources[j].Service
+		}
+		return sources[i].Bucket < sources[j].Bucket
+	})
+	return read, sources
+}
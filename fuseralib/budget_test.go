@@ -0,0 +1,187 @@
+package fuseralib_test
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/mitre/fusera/fuseralib"
+)
+
+// reserve A call to Budget.Reserve and what it should return.
+type reserve struct {
+	acc         string
+	payRequired bool
+	n           uint64
+	err         error
+}
+
+func TestReserve(t *testing.T) {
+	tests := []struct {
+		name              string
+		limit, accLimit   uint64
+		requesterPaysOnly bool
+		reserves          []reserve
+	}{
+		{"no limits", 0, 0, false, []reserve{
+			{"SRR1", false, 1 << 40, nil},
+			{"SRR2", true, 1 << 40, nil},
+		}},
+		{"total limit", 100, 0, false, []reserve{
+			{"SRR1", false, 60, nil},
+			{"SRR2", false, 40, nil},
+			{"SRR3", false, 1, syscall.EDQUOT},
+		}},
+		{"read that would go over is refused whole", 100, 0, false, []reserve{
+			{"SRR1", false, 90, nil},
+			{"SRR1", false, 20, syscall.EDQUOT},
+			{"SRR1", false, 10, nil},
+		}},
+		{"accession limit", 0, 50, false, []reserve{
+			{"SRR1", false, 50, nil},
+			{"SRR1", false, 1, syscall.EDQUOT},
+			{"SRR2", false, 50, nil},
+		}},
+		{"both limits", 80, 50, false, []reserve{
+			{"SRR1", false, 50, nil},
+			{"SRR2", false, 40, syscall.EDQUOT},
+			{"SRR2", false, 30, nil},
+		}},
+		{"requester pays only", 100, 0, true, []reserve{
+			{"SRR1", false, 1000, nil},
+			{"SRR2", true, 100, nil},
+			{"SRR2", true, 1, syscall.EDQUOT},
+			{"SRR1", false, 1000, nil},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fuseralib.NewBudget(tt.limit, tt.accLimit, 0, tt.requesterPaysOnly)
+			for i, r := range tt.reserves {
+				if _, err := b.Reserve(r.acc, r.payRequired, r.n); err != r.err {
+					t.Errorf("reserve %d of %d bytes of %s: err = %v, want %v", i, r.n, r.acc, err, r.err)
+				}
+			}
+		})
+	}
+}
+
+func TestSettleGivesBackWhatWasntRead(t *testing.T) {
+	tests := []struct {
+		name string
+		read int
+		// left How much more can be reserved after settling.
+		left uint64
+	}{
+		{"all read", 100, 0},
+		{"some read", 40, 60},
+		{"none read", 0, 100},
+		{"read failed", -1, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := fuseralib.NewBudget(100, 100, 0, false)
+			r, err := b.Reserve("SRR1", false, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Settle(r, "s3", "bucket", tt.read)
+			if tt.left > 0 {
+				if _, err := b.Reserve("SRR1", false, tt.left); err != nil {
+					t.Errorf("couldn't reserve the %d bytes given back: %v", tt.left, err)
+				}
+			}
+			if _, err := b.Reserve("SRR1", false, 1); err != syscall.EDQUOT {
+				t.Errorf("reserved more than was given back: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestBudgetRollsOverEachPeriod(t *testing.T) {
+	b := fuseralib.NewBudget(100, 0, 50*time.Millisecond, false)
+	old, err := b.Reserve("SRR1", false, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Reserve("SRR1", false, 1); err != syscall.EDQUOT {
+		t.Fatalf("err = %v, want EDQUOT before the period is over", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := b.Reserve("SRR1", false, 100); err != nil {
+		t.Fatalf("couldn't reserve in a new period: %v", err)
+	}
+	// Giving back bytes reserved in the last period would take them from this one's count.
+	b.Settle(old, "s3", "bucket", 0)
+	if _, err := b.Reserve("SRR1", false, 1); err != syscall.EDQUOT {
+		t.Errorf("err = %v, want EDQUOT since the last period's reservation isn't given back to this one", err)
+	}
+}
+
+func TestSourcesCountEveryByteRead(t *testing.T) {
+	b := fuseralib.NewBudget(0, 0, 0, true)
+	settles := []struct {
+		service, bucket string
+		payRequired     bool
+		n               int
+	}{
+		{"s3", "b", true, 10},
+		{"gs", "a", false, 5},
+		{"s3", "a", false, 7},
+		{"s3", "b", false, 3},
+		{"s3", "a", true, 0},
+		{"gs", "a", true, -1},
+	}
+	for _, s := range settles {
+		r, err := b.Reserve("SRR1", s.payRequired, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Settle(r, s.service, s.bucket, s.n)
+	}
+	read, sources := b.Sources()
+	want := []fuseralib.Source{{Service: "gs", Bucket: "a"}, {Service: "s3", Bucket: "a"}, {Service: "s3", Bucket: "b"}}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("sources = %v, want %v", sources, want)
+	}
+	wantRead := map[fuseralib.Source]uint64{want[0]: 5, want[1]: 7, want[2]: 13}
+	if !reflect.DeepEqual(read, wantRead) {
+		t.Errorf("bytes read = %v, want %v, counting files that don't count against the limits", read, wantRead)
+	}
+}
+
+func TestNilBudgetAllowsEverything(t *testing.T) {
+	var b *fuseralib.Budget
+	r, err := b.Reserve("SRR1", true, 1<<60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Settle(r, "s3", "bucket", 1)
+	if read, sources := b.Sources(); read != nil || sources != nil {
+		t.Errorf("sources = %v, want none", sources)
+	}
+}
+
+func TestReadOverBudgetIsEDQUOT(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	fs := newFusera(t, &fuseralib.Options{API: api, Budget: fuseralib.NewBudget(6000, 0, 0, false)})
+	handle := open(t, fs, fuseops.OpContext{})
+
+	if _, err := read(fs, handle, 0, 4096); err != nil {
+		t.Fatalf("couldn't read within budget: %v", err)
+	}
+	gets := server.Store.Gets("SRR1/a.cram")
+	// Read elsewhere, so a new request would be needed.
+	if _, err := read(fs, handle, 8192, 4096); err != syscall.EDQUOT {
+		t.Fatalf("err = %v, want EDQUOT", err)
+	}
+	if n := server.Store.Gets("SRR1/a.cram"); n != gets {
+		t.Errorf("a.cram was got %d more times, want no request for a read over budget", n-gets)
+	}
+	if data, err := read(fs, handle, 4096, 1904); err != nil || len(data) != 1904 {
+		t.Errorf("read %d bytes, %v, want the 1904 left in the budget", len(data), err)
+	}
+}
@@ -0,0 +1,237 @@
+package fuseralib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Transfer How far a file travels to be read, which decides what its bytes cost.
+type Transfer string
+
+const (
+	// TransferInRegion The file is in the same region of the same cloud as the location.
+	TransferInRegion Transfer = "in-region"
+	// TransferCrossRegion The file is in a different region of the same cloud.
+	TransferCrossRegion Transfer = "cross-region"
+	// TransferCrossCloud The file is in a different cloud, so it's read over the internet.
+	TransferCrossCloud Transfer = "cross-cloud"
+	// TransferUnknown The SDL API didn't say where the file is.
+	TransferUnknown Transfer = "unknown"
+)
+
+// The payment classes of a file: whether its owner or the requester (you) pays to read it.
+const (
+	PaymentOwner     = "owner"
+	PaymentRequester = "requester"
+)
+
+// Prices What a cloud charges per GB to read out of its storage, by how far the bytes travel.
+type Prices struct {
+	InRegion    float64 `json:"inRegion"`
+	CrossRegion float64 `json:"crossRegion"`
+	CrossCloud  float64 `json:"crossCloud"`
+}
+
+// PriceTable What each cloud charges per GB to read out of its storage, keyed by the SDL API's name
+// for the cloud, such as s3 or gs. Only requester pays files are charged to the reader.
+type PriceTable struct {
+	Currency string            `json:"currency"`
+	Clouds   map[string]Prices `json:"clouds"`
+}
+
+// DefaultPrices Approximate list prices for the first tier of transfer out of each cloud's storage.
+// They change and depend on the regions involved, so give a price table for anything that matters.
+var DefaultPrices = PriceTable{
+	Currency: "USD",
+	Clouds: map[string]Prices{
+		"s3":    {InRegion: 0, CrossRegion: 0.02, CrossCloud: 0.09},
+		"gs":    {InRegion: 0, CrossRegion: 0.02, CrossCloud: 0.12},
+		"azure": {InRegion: 0, CrossRegion: 0.02, CrossCloud: 0.087},
+	},
+}
+
+// LoadPriceTable Reads a PriceTable from the json file at path.
+// Clouds it leaves out keep their DefaultPrices.
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return PriceTable{}, errors.Wrapf(err, "couldn't read price table: %s", path)
+	}
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return PriceTable{}, errors.Wrapf(err, "couldn't parse price table: %s", path)
+	}
+	if table.Currency == "" {
+		table.Currency = DefaultPrices.Currency
+	}
+	for cloud, prices := range DefaultPrices.Clouds {
+		if _, ok := table.Clouds[cloud]; !ok {
+			if table.Clouds == nil {
+				table.Clouds = make(map[string]Prices)
+			}
+			table.Clouds[cloud] = prices
+		}
+	}
+	return table, nil
+}
+
+// perGB Returns what a GB of transfer costs out of cloud, and whether the table has a price for it.
+func (t PriceTable) perGB(cloud string, transfer Transfer) (float64, bool) {
+	prices, ok := t.Clouds[cloud]
+	if !ok {
+		return 0, false
+	}
+	switch transfer {
+	case TransferInRegion:
+		return prices.InRegion, true
+	case TransferCrossRegion:
+		return prices.CrossRegion, true
+	case TransferCrossCloud:
+		return prices.CrossCloud, true
+	}
+	return 0, false
+}
+
+// EstimateLine The files of a cart stored in one region and paid for the same way.
+type EstimateLine struct {
+	Service  string   `json:"service"`
+	Region   string   `json:"region"`
+	Payment  string   `json:"payment"`
+	Transfer Transfer `json:"transfer"`
+	Files    int      `json:"files"`
+	Bytes    uint64   `json:"bytes"`
+	Cost     float64  `json:"cost"`
+	// Unpriced Whether the price table had no price for these files, so their cost isn't counted.
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+// Estimate What reading every file of a cart from a location is expected to cost.
+type Estimate struct {
+	Cloud      string         `json:"cloud"`
+	Region     string         `json:"region"`
+	Currency   string         `json:"currency"`
+	Lines      []EstimateLine `json:"lines"`
+	TotalBytes uint64         `json:"totalBytes"`
+	TotalCost  float64        `json:"totalCost"`
+	// Errors The accessions the SDL API had errors for, whose files aren't counted.
+	Errors []Listing `json:"errors,omitempty"`
+}
+
+// gigabyte The unit clouds charge transfer by.
+const gigabyte = 1 << 30
+
+// transfer Returns how far f travels to be read from region of cloud.
+func transfer(cloud, region string, f File) Transfer {
+	switch {
+	case f.Service == "":
+		return TransferUnknown
+	case f.Service != cloud:
+		return TransferCrossCloud
+	case f.Region == "" || region == "" || sameRegion(cloud, region, f.Region):
+		return TransferInRegion
+	}
+	return TransferCrossRegion
+}
+
+// NewEstimate Totals the bytes of accs by the service and region they're stored in and who pays for them,
+// costing those the requester pays for with prices as if read from region of cloud.
+func NewEstimate(accs []*Accession, cloud, region string, prices PriceTable) *Estimate {
+	est := &Estimate{Cloud: cloud, Region: region, Currency: prices.Currency}
+	type key struct {
+		service, region, payment string
+		transfer                 Transfer
+	}
+	lines := make(map[key]*EstimateLine)
+	for _, a := range accs {
+		if a.HasError() {
+			est.Errors = append(est.Errors, Listing{Accession: a.ID, Error: a.ErrorLog()})
+			continue
+		}
+		for _, f := range a.Files {
+			payment := PaymentOwner
+			if f.PayRequired {
+				payment = PaymentRequester
+			}
+			k := key{f.Service, f.Region, payment, transfer(cloud, region, f)}
+			line, ok := lines[k]
+			if !ok {
+				line = &EstimateLine{Service: k.service, Region: k.region, Payment: k.payment, Transfer: k.transfer}
+				lines[k] = line
+			}
+			line.Files++
+			line.Bytes += f.Size
+			est.TotalBytes += f.Size
+		}
+	}
+	for _, line := range lines {
+		// Reading files the owner pays for costs the reader nothing.
+		if line.Payment == PaymentRequester {
+			perGB, ok := prices.perGB(line.Service, line.Transfer)
+			line.Unpriced = !ok
+			line.Cost = float64(line.Bytes) / gigabyte * perGB
+			est.TotalCost += line.Cost
+		}
+		est.Lines = append(est.Lines, *line)
+	}
+	sort.Slice(est.Lines, func(i, j int) bool {
+		a, b := est.Lines[i], est.Lines[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Payment < b.Payment
+	})
+	return est
+}
+
+// WriteEstimate Writes est to w, as JSON if asJSON, otherwise as a table followed by the total.
+// Accessions with errors follow the total, one per line.
+func WriteEstimate(w io.Writer, est *Estimate, asJSON bool) error {
+	if asJSON {
+		if est.Lines == nil {
+			est.Lines = []EstimateLine{}
+		}
+		data, err := json.MarshalIndent(est, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "couldn't encode estimate")
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tREGION\tPAYMENT\tTRANSFER\tFILES\tBYTES\tCOST")
+	unpriced := false
+	for _, l := range est.Lines {
+		cost := fmt.Sprintf("%.2f", l.Cost)
+		if l.Unpriced {
+			cost = "?"
+			unpriced = true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", l.Service, l.Region, l.Payment, l.Transfer, l.Files, l.Bytes, cost)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Estimated cost of reading %d bytes from %s.%s: %.2f %s\n", est.TotalBytes, est.Cloud, est.Region, est.TotalCost, est.Currency); err != nil {
+		return err
+	}
+	if unpriced {
+		if _, err := fmt.Fprintln(w, "Files marked ? have no price in the price table and aren't counted."); err != nil {
+			return err
+		}
+	}
+	for _, l := range est.Errors {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", l.Accession, l.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -21,11 +21,9 @@ import (
 	"io"
 	"io/ioutil"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/mattrbianchi/twig"
-	"github.com/mitre/fusera/awsutil"
 	"github.com/mitre/fusera/flags"
 	"github.com/pkg/errors"
 
@@ -60,13 +58,20 @@ type FileHandle struct {
 	existingReadahead int
 	seqReadAmount     uint64
 	numOOORead        uint64 // number of out of order read
+
+	// audit
+	// uid, pid The user and process that opened the file. The kernel doesn't say who closes it.
+	uid, pid  uint32
+	opened    time.Time
+	bytesRead uint64
+	readErr   error
 }
 
 const MaxReadAhead = uint32(100 * 1024 * 1024)
 const ReadAheadChunk = uint32(20 * 1024 * 1024)
 
 func NewFileHandle(in *Inode) *FileHandle {
-	fh := &FileHandle{inode: in}
+	fh := &FileHandle{inode: in, opened: time.Now()}
 	return fh
 }
 
@@ -145,6 +150,10 @@ func (fh *FileHandle) ReadFile(offset int64, buf []byte) (bytesRead int, err err
 			bytesRead += nread
 		}
 	}
+	fh.bytesRead += uint64(bytesRead)
+	if err != nil && err != io.EOF {
+		fh.readErr = err
+	}
 
 	return
 }
@@ -246,19 +255,35 @@ func (fh *FileHandle) readFromStream(offset int64, buf []byte) (bytesRead int, e
 		return
 	}
 
-	byteRange := ""
-	if offset != 0 {
-		byteRange = fmt.Sprintf("bytes=%v-", offset)
+	// The budget is checked before a reader is populated, so a read over budget doesn't open a request to the file.
+	// error.log files are made up, not read from anywhere, so they aren't counted.
+	var reservation Reservation
+	counted := fh.inode.ErrContents == ""
+	if counted {
+		want := uint64(len(buf))
+		if left := fh.inode.Attributes.Size - uint64(offset); left < want {
+			want = left
+		}
+		reservation, err = fh.inode.fs.budget.Reserve(fh.inode.Acc, fh.inode.ReqPays, want)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	if fh.reader == nil {
-		fh.reader, err = populateReader(fh, byteRange)
+		fh.reader, err = populateReader(fh, offset)
 		if err != nil {
+			if counted {
+				fh.inode.fs.budget.Settle(reservation, fh.inode.Service, fh.inode.Bucket, 0)
+			}
 			return 0, err
 		}
 	}
 
 	bytesRead, err = fh.reader.Read(buf)
+	if counted {
+		fh.inode.fs.budget.Settle(reservation, fh.inode.Service, fh.inode.Bucket, bytesRead)
+	}
 	if err != nil {
 		if flags.Verbose {
 			fmt.Println("error reading file")
@@ -277,82 +302,40 @@ func (fh *FileHandle) readFromStream(offset int64, buf []byte) (bytesRead int, e
 	return
 }
 
-func populateReader(fh *FileHandle, byteRange string) (io.ReadCloser, error) {
+func populateReader(fh *FileHandle, offset int64) (io.ReadCloser, error) {
 	if fh.inode.ErrContents != "" {
 		// This is an error.log file, need to read from its error contents.
 		return ioutil.NopCloser(bytes.NewBufferString(fh.inode.ErrContents)), nil
 	}
-	if fh.inode.ReqPays {
-		client := awsutil.NewClient(fh.inode.Bucket, fh.inode.Key, fh.inode.Region, fh.inode.fs.opt.CloudProfile)
-		body, err := client.GetObjectRange(byteRange)
-		if err != nil {
-			return nil, syscall.EACCES
-		}
-		return body, nil
-	}
 
-	// So now we need to handle Compute Environment Required,
-	// because that doesn't have an expiration date, but requires us to add
-	// an ident parameter to the link in order for it to work.
-	link, err := addIdentIfRequired(fh)
-	if err != nil {
-		return nil, syscall.EACCES
+	inode := fh.inode
+	inode.mu.Lock()
+	f := File{
+		Name:           *inode.Name,
+		Link:           inode.Link,
+		ExpirationDate: inode.Attributes.ExpirationDate,
+		PayRequired:    inode.ReqPays,
+		Bucket:         inode.Bucket,
+		Key:            inode.Key,
+		Service:        inode.Service,
+		Region:         inode.Region,
+		CeRequired:     inode.CeRequired,
 	}
-
-	// Then, technically, we don't need this code below until we implement the code to snatch the link
-	// from the redirection along with its expiration date. Yuck.
-	// When I do start this, I'll need to keep the CeRequired link in a field so that I don't lose it.
-	// Then I'll need logic to check whether we have an unexpired link we can keep using or whether we need to
-	// use the CeRequired link to refresh the contemporary link.
-
-	// sd, _ := time.ParseDuration("30s")
-	// exp := fh.inode.Attributes.ExpirationDate
-	// if (fh.inode.Link == "") || (!exp.IsZero() && time.Until(exp) < sd) { // We need a new link
-	// 	link, expiration, err := newURL(fh.inode)
-	// 	if err != nil {
-	// 		return nil, syscall.EACCES
-	// 	}
-	// 	fh.inode.Link = link
-	// 	fh.inode.Attributes.ExpirationDate = expiration
-	// }
-	resp, err := awsutil.GetObjectRange(link, byteRange)
+	inode.mu.Unlock()
+
+	// Renewing the link asks the SDL API, so it's done without holding the inode's lock.
+	body, err := inode.fs.reader.OpenRange(inode.Acc, &f, offset)
+	inode.mu.Lock()
+	inode.Link = f.Link
+	inode.Attributes.ExpirationDate = f.ExpirationDate
+	inode.Service = f.Service
+	inode.Region = f.Region
+	inode.mu.Unlock()
 	if err != nil {
-		return nil, err
-	}
-
-	return resp.Body, nil
-}
-
-func addIdentIfRequired(fh *FileHandle) (string, error) {
-	if fh.inode.CeRequired {
-		return fh.inode.fs.signer.AddIdent(fh.inode.Link)
-	}
-	return fh.inode.Link, nil
-}
-
-func newURL(inode *Inode) (string, time.Time, error) {
-	accession, err := inode.fs.signer.Sign(inode.Acc)
-	if err != nil {
-		return "", time.Now(), errors.Wrapf(err, "issue contacting API while trying to renew signed url for:\naccession: %s\nfile: %s\n", inode.Acc, *inode.Name)
-	}
-	if flags.Verbose {
-		fmt.Println("got a response from API")
-	}
-	for _, f := range accession.Files {
-		if f.Name == *inode.Name {
-			if f.Link == "" {
-				return "", time.Now(), errors.Errorf("API did not give new signed url for:\naccession: %s\nfile: %s\n", inode.Acc, *inode.Name)
-			}
-			if flags.Verbose {
-				fmt.Printf("got a new link: %s\n", f.Link)
-			}
-			return f.Link, f.ExpirationDate, nil
-		}
-	}
-	if flags.Verbose {
-		twig.Debug("did not get a new link")
+		twig.Debug(err)
+		return nil, errors.Cause(err)
 	}
-	return "", time.Now(), errors.Errorf("couldn't get new signed url for:\naccession: %s\nfile: %s\n", inode.Acc, *inode.Name)
+	return body, nil
 }
 
 func (fh *FileHandle) resetToKnownSize() {
@@ -51,6 +51,7 @@ type Inode struct {
 	ReqPays     bool
 	Bucket      string
 	Key         string
+	Service     string
 	Region      string
 	CeRequired  bool
 
@@ -394,6 +395,11 @@ func (inode *Inode) OpenFile() (fh *FileHandle, err error) {
 	return
 }
 
+// audited Whether opening the inode is recorded in the audit log: files of accessions are, error.log files aren't.
+func (inode *Inode) audited() bool {
+	return inode.Acc != "" && inode.ErrContents == ""
+}
+
 func (parent *Inode) addDotAndDotDot() {
 	twig.Debug("handle.go/addDotAndDotDot called")
 	fs := parent.fs
@@ -0,0 +1,99 @@
+package fuseralib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Listing A line of what's in a cart: a file of an accession, or an accession the SDL API had an error for.
+type Listing struct {
+	Accession   string `json:"accession"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Size        uint64 `json:"size"`
+	Md5Hash     string `json:"md5,omitempty"`
+	Service     string `json:"service,omitempty"`
+	Region      string `json:"region,omitempty"`
+	PayRequired bool   `json:"payRequired"`
+	CeRequired  bool   `json:"ceRequired"`
+	Error       string `json:"error,omitempty"`
+}
+
+// List Returns a Listing for every file of accs, in the order the accessions were given and by name within each.
+func List(accs []*Accession) []Listing {
+	var list []Listing
+	for _, a := range accs {
+		if a.HasError() {
+			list = append(list, Listing{Accession: a.ID, Error: a.ErrorLog()})
+			continue
+		}
+		names := make([]string, 0, len(a.Files))
+		for name := range a.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f := a.Files[name]
+			list = append(list, Listing{
+				Accession:   a.ID,
+				Name:        f.Name,
+				Type:        f.Type,
+				Size:        f.Size,
+				Md5Hash:     f.Md5Hash,
+				Service:     f.Service,
+				Region:      f.Region,
+				PayRequired: f.PayRequired,
+				CeRequired:  f.CeRequired,
+			})
+		}
+	}
+	return list
+}
+
+// WriteListing Writes what's in accs to w, as a JSON array of Listing if asJSON, otherwise as a table.
+// Accessions with errors follow the table, one per line.
+func WriteListing(w io.Writer, accs []*Accession, asJSON bool) error {
+	list := List(accs)
+	if asJSON {
+		if list == nil {
+			list = []Listing{}
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "couldn't encode listing")
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCESSION\tNAME\tTYPE\tSIZE\tMD5\tSERVICE\tREGION\tPAY\tCE")
+	var failed []Listing
+	for _, l := range list {
+		if l.Error != "" {
+			failed = append(failed, l)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", l.Accession, l.Name, l.Type, l.Size, l.Md5Hash, l.Service, l.Region, yesNo(l.PayRequired), yesNo(l.CeRequired))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, l := range failed {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", l.Accession, l.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,129 @@
+package fuseralib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestVersion The version of the manifest format written by SaveManifest.
+const ManifestVersion = "1"
+
+// Manifest A record of the accessions resolved by the SDL API.
+// It holds everything needed to build the file system without contacting the SDL API again,
+// which makes restarts fast and leaves a record of exactly what was mounted.
+type Manifest struct {
+	Version    string               `json:"version"`
+	SdlVersion string               `json:"sdlVersion,omitempty"`
+	Created    time.Time            `json:"created"`
+	Accessions []*manifestAccession `json:"accessions"`
+}
+
+// manifestAccession Accession keeps its errors private, so they're carried alongside it in order to recreate error.log files.
+type manifestAccession struct {
+	*Accession
+	Errors string `json:"errors,omitempty"`
+}
+
+// BadManifestError An error reading a manifest, as opposed to writing one.
+type BadManifestError struct {
+	error
+}
+
+// NewManifest Returns a Manifest of accs, resolved using the given version of the SDL API.
+func NewManifest(sdlVersion string, accs []*Accession) *Manifest {
+	m := &Manifest{
+		Version:    ManifestVersion,
+		SdlVersion: sdlVersion,
+		Created:    time.Now().UTC(),
+		Accessions: make([]*manifestAccession, 0, len(accs)),
+	}
+	for _, a := range accs {
+		m.Accessions = append(m.Accessions, &manifestAccession{Accession: a, Errors: a.ErrorLog()})
+	}
+	return m
+}
+
+// List Returns the accessions recorded in the manifest.
+func (m *Manifest) List() []*Accession {
+	accs := make([]*Accession, 0, len(m.Accessions))
+	for _, a := range m.Accessions {
+		if a.Accession == nil {
+			continue
+		}
+		if a.Errors != "" && !a.HasError() {
+			a.AppendError(a.Errors)
+		}
+		if a.Files == nil {
+			a.Files = make(map[string]File)
+		}
+		accs = append(accs, a.Accession)
+	}
+	return accs
+}
+
+// IDs Returns the IDs of the accessions recorded in the manifest.
+func (m *Manifest) IDs() []string {
+	ids := make([]string, 0, len(m.Accessions))
+	for _, a := range m.Accessions {
+		if a.Accession != nil {
+			ids = append(ids, a.ID)
+		}
+	}
+	return ids
+}
+
+// SaveManifest Writes a manifest of accs to path as JSON.
+// Only the user writing it can read it, since it holds signed links.
+func SaveManifest(path, sdlVersion string, accs []*Accession) error {
+	data, err := json.MarshalIndent(NewManifest(sdlVersion, accs), "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode manifest")
+	}
+	// Written next to path and renamed into place, so it's never readable by others, even briefly,
+	// and a manifest already at path is only replaced by a whole one.
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return errors.Wrapf(err, "couldn't write manifest file at: %s", path)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "couldn't write manifest file at: %s", path)
+	}
+	return nil
+}
+
+// LoadManifest Reads a manifest written by SaveManifest from path, whose links are renewed with sdlVersion of the SDL API.
+// An empty sdlVersion takes a manifest resolved with any version. Errors are a *BadManifestError.
+func LoadManifest(path, sdlVersion string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, &BadManifestError{errors.Wrapf(err, "couldn't open manifest file at: %s", path)}
+	}
+	defer f.Close()
+	m := &Manifest{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, &BadManifestError{errors.Wrapf(err, "couldn't decode manifest file at: %s", path)}
+	}
+	if m.Version != ManifestVersion {
+		return nil, &BadManifestError{errors.Errorf("manifest file at: %s is version %s, expected version %s", path, m.Version, ManifestVersion)}
+	}
+	if sdlVersion != "" && m.SdlVersion != "" && m.SdlVersion != sdlVersion {
+		return nil, &BadManifestError{errors.Errorf("manifest file at: %s was resolved with SDL API version %s, but sdl-version is %s", path, m.SdlVersion, sdlVersion)}
+	}
+	if len(m.Accessions) == 0 {
+		return nil, &BadManifestError{errors.Errorf("manifest file at: %s was empty", path)}
+	}
+	return m, nil
+}
@@ -0,0 +1,95 @@
+package fuseralib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+func accessions() []*fuseralib.Accession {
+	return []*fuseralib.Accession{{
+		ID: "SRR1",
+		Files: map[string]fuseralib.File{
+			"a.cram": {Name: "a.cram", Size: 9, Link: "https://bucket/a.cram?sig=secret", ExpirationDate: time.Now().Add(time.Hour)},
+		},
+	}}
+}
+
+func TestSavedManifestIsLoaded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := fuseralib.SaveManifest(path, "2", accessions()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := fuseralib.LoadManifest(path, "2")
+	if err != nil {
+		t.Fatalf("couldn't load manifest: %v", err)
+	}
+	accs := m.List()
+	if len(accs) != 1 || accs[0].ID != "SRR1" || accs[0].Files["a.cram"].Link != "https://bucket/a.cram?sig=secret" {
+		t.Errorf("accessions = %+v, want SRR1 with a.cram and its link", accs)
+	}
+}
+
+func TestSavedManifestIsOnlyReadableByOwner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	// A manifest already there, readable by anyone, is replaced by one that isn't.
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fuseralib.SaveManifest(path, "2", accessions()); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %v, want 0600 since the manifest holds signed links", mode)
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("directory holds %d files, want only the manifest", len(files))
+	}
+}
+
+func TestManifestOfOtherSdlVersionIsRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := fuseralib.SaveManifest(path, "2", accessions()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fuseralib.LoadManifest(path, "3"); err == nil {
+		t.Error("loaded a manifest resolved with version 2 for version 3")
+	}
+	if _, err := fuseralib.LoadManifest(path, ""); err != nil {
+		t.Errorf("couldn't load the manifest without a version: %v", err)
+	}
+}
+
+func TestOnlyLoadErrorsAreBadManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(empty, []byte(`{"version": "1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(dir, "missing.json"), empty} {
+		_, err := fuseralib.LoadManifest(path, "2")
+		if _, ok := errors.Cause(err).(*fuseralib.BadManifestError); !ok {
+			t.Errorf("loading %s gave %v, want a *BadManifestError", filepath.Base(path), err)
+		}
+	}
+	err := fuseralib.SaveManifest(filepath.Join(dir, "missing", "manifest.json"), "2", accessions())
+	if err == nil {
+		t.Fatal("saved a manifest into a directory that doesn't exist")
+	}
+	if _, ok := errors.Cause(err).(*fuseralib.BadManifestError); ok {
+		t.Errorf("saving gave a *BadManifestError: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package fuseralib
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/awsutil"
+	"github.com/mitre/fusera/flags"
+	"github.com/pkg/errors"
+
+	"github.com/jacobsa/fuse"
+)
+
+// How close to its expiration date a link can get before it's renewed.
+const linkRenewalWindow = 30 * time.Second
+
+// Reader Reads the bytes of files the way Fusera does, whether they're
+// behind a signed link, in a requester pays bucket, or require a compute
+// environment. Anything that copies files, not just the file system,
+// should read them through a Reader.
+//
+// Errors returned by a Reader can be passed to errors.Cause to get the
+// errno the file system answers with.
+type Reader struct {
+	API API
+	// Region The region requester pays buckets are asked for in.
+	Region string
+	// Profile The credentials profile charged for requester pays files.
+	Profile string
+	// S3Endpoint Where requester pays files are read from instead of S3, such as a fake object store in tests.
+	S3Endpoint string
+	// S3Credentials What requester pays reads are signed with instead of the Profile's credentials, if set.
+	S3Credentials *credentials.Credentials
+	// Guard Refuses or warns about reads of files outside the region, if set.
+	Guard *RegionGuard
+}
+
+// NewReader Returns a Reader that renews links with api and charges requester pays files to profile.
+func NewReader(api API, region, profile string) *Reader {
+	return &Reader{
+		API:     api,
+		Region:  region,
+		Profile: profile,
+	}
+}
+
+// OpenRange Renews f's link if needed, then returns f's bytes starting at offset.
+func (r *Reader) OpenRange(acc string, f *File, offset int64) (io.ReadCloser, error) {
+	return r.openRenewed(acc, f, offset, -1)
+}
+
+// Renew Asks the SDL API to sign a new link for f, a file of acc, when it
+// has none or its current one is about to expire. Links are only good until
+// their expiration date, which is long gone when the files came from a
+// manifest written days ago.
+func (r *Reader) Renew(acc string, f *File) error {
+	return r.renew(acc, f, false)
+}
+
+// renew Renews f's link if needed, or no matter its expiration date if force.
+func (r *Reader) renew(acc string, f *File, force bool) error {
+	if f.PayRequired {
+		// Requester pays files are read by bucket and key, not by link.
+		return nil
+	}
+	exp := f.ExpirationDate
+	if !force && f.Link != "" && (exp.IsZero() || time.Until(exp) > linkRenewalWindow) {
+		return nil
+	}
+	accession, err := r.API.Sign(acc)
+	if err != nil {
+		return errors.Wrapf(syscall.EACCES, "issue contacting API while trying to renew signed url for:\naccession: %s\nfile: %s\n%s", acc, f.Name, err.Error())
+	}
+	if flags.Verbose {
+		fmt.Println("got a response from API")
+	}
+	renewed, ok := accession.Files[f.Name]
+	if !ok || renewed.Link == "" {
+		if flags.Verbose {
+			twig.Debug("did not get a new link")
+		}
+		return errors.Wrapf(syscall.EACCES, "API did not give new signed url for:\naccession: %s\nfile: %s\n", acc, f.Name)
+	}
+	if flags.Verbose {
+		fmt.Printf("got a new link: %s\n", renewed.Link)
+	}
+	f.Link = renewed.Link
+	f.ExpirationDate = renewed.ExpirationDate
+	// The new link may be somewhere else.
+	if renewed.Service != "" {
+		f.Service = renewed.Service
+		f.Region = renewed.Region
+	}
+	return nil
+}
+
+// Open Returns f's bytes starting at offset, without renewing its link.
+func (r *Reader) Open(f File, offset int64) (io.ReadCloser, error) {
+	return r.open(f, offset, -1)
+}
+
+// OpenSection Renews f's link if needed, then returns length bytes of f starting at offset,
+// for reading parts of a file at the same time.
+func (r *Reader) OpenSection(acc string, f *File, offset, length int64) (io.ReadCloser, error) {
+	return r.openRenewed(acc, f, offset, length)
+}
+
+// openRenewed Renews f's link if needed, then returns length bytes of f starting at offset.
+// A link refused by the bucket is renewed and tried once more, since a bucket can say a link
+// has expired before the expiration date the SDL API gave, such as when clocks disagree.
+func (r *Reader) openRenewed(acc string, f *File, offset, length int64) (io.ReadCloser, error) {
+	if err := r.renew(acc, f, false); err != nil {
+		return nil, err
+	}
+	if err := r.Guard.Check(acc, *f); err != nil {
+		return nil, err
+	}
+	body, err := r.open(*f, offset, length)
+	if errors.Cause(err) != syscall.EACCES || !expires(*f) {
+		return body, err
+	}
+	twig.Debugf("link of %s/%s was refused, renewing it: %s", acc, f.Name, err.Error())
+	if err := r.renew(acc, f, true); err != nil {
+		return nil, err
+	}
+	if err := r.Guard.Check(acc, *f); err != nil {
+		return nil, err
+	}
+	return r.open(*f, offset, length)
+}
+
+// expires Whether f is read through a signed link that expires and can be renewed.
+func expires(f File) bool {
+	return !f.PayRequired && !f.CeRequired && !strings.HasPrefix(f.Link, "file://")
+}
+
+// open Returns length bytes of f starting at offset, or all of them past offset if length is negative.
+func (r *Reader) open(f File, offset, length int64) (io.ReadCloser, error) {
+	byteRange := ""
+	if length >= 0 {
+		byteRange = fmt.Sprintf("bytes=%v-%v", offset, offset+length-1)
+	} else if offset != 0 {
+		byteRange = fmt.Sprintf("bytes=%v-", offset)
+	}
+	if f.PayRequired {
+		region := r.Region
+		if region == "" {
+			region = f.Region
+		}
+		client := awsutil.NewClient(f.Bucket, f.Key, region, r.Profile)
+		client.Endpoint, client.Credentials = r.S3Endpoint, r.S3Credentials
+		body, err := client.GetObjectRange(byteRange)
+		if err != nil {
+			return nil, errors.Wrapf(syscall.EACCES, "couldn't read requester pays file: %s from bucket: %s: %s", f.Name, f.Bucket, err.Error())
+		}
+		return body, nil
+	}
+
+	link := f.Link
+	// Compute Environment Required links don't expire, but require us to
+	// add an ident parameter to the link in order for them to work.
+	if f.CeRequired {
+		var err error
+		link, err = r.API.AddIdent(link)
+		if err != nil {
+			return nil, errors.Wrapf(syscall.EACCES, "couldn't prove compute environment for file: %s: %s", f.Name, err.Error())
+		}
+	}
+
+	if strings.HasPrefix(link, "file://") {
+		// Local data stands in for a bucket during development and testing.
+		if !r.servesLocalFiles() {
+			return nil, errors.Wrapf(syscall.EACCES, "refusing to read local file link of file: %s, only local data may have them", f.Name)
+		}
+		body, err := openLocalRange(strings.TrimPrefix(link, "file://"), offset)
+		if err != nil || length < 0 {
+			return body, err
+		}
+		return limitReadCloser(body, length), nil
+	}
+	resp, err := awsutil.GetObjectRange(link, byteRange)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read file: %s", f.Name)
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		return resp.Body, nil
+	}
+	// The server ignored the range and is sending the whole file.
+	if offset != 0 {
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, errors.Wrapf(err, "couldn't skip to offset %d of file: %s", offset, f.Name)
+		}
+	}
+	if length >= 0 {
+		return limitReadCloser(resp.Body, length), nil
+	}
+	return resp.Body, nil
+}
+
+// servesLocalFiles Whether r's API is local data, whose file:// links may be read.
+func (r *Reader) servesLocalFiles() bool {
+	local, ok := r.API.(LocalSource)
+	return ok && local.ServesLocalFiles()
+}
+
+// limitReadCloser Returns rc, ending after n bytes.
+func limitReadCloser(rc io.ReadCloser, n int64) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(rc, n), rc}
+}
+
+func openLocalRange(path string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(fuse.ENOENT, "couldn't find local file: %s", path)
+		}
+		return nil, errors.Wrapf(syscall.EACCES, "couldn't open local file: %s", path)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(fuse.EINVAL, "couldn't seek to offset %d of local file: %s", offset, path)
+	}
+	return f, nil
+}
@@ -0,0 +1,191 @@
+package fuseralib_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/mitre/fusera/gps"
+	"github.com/mitre/fusera/info"
+	"github.com/mitre/fusera/mock/sdltest"
+	"github.com/mitre/fusera/sdl"
+	"github.com/pkg/errors"
+)
+
+var cram = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// serve Starts a fake SDL API serving SRR1 with a.cram, and returns an SDL asking it for SRR1 from s3.us-east-1.
+// Close the server when done.
+func serve(t *testing.T) (*sdltest.Server, *sdl.SDL) {
+	t.Helper()
+	server := sdltest.NewServer()
+	if err := server.AddAccession("SRR1", sdltest.File{Name: "a.cram", Type: "cram", Data: cram}); err != nil {
+		t.Fatal(err)
+	}
+	location, err := gps.NewManualLocation("s3.us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags.Silent = true
+	info.LoadAccessionMap([]string{"SRR1"})
+	api := sdl.NewSDL()
+	api.URL = server.URL()
+	api.Param = sdl.NewParam([]string{"SRR1"}, location, nil, "", nil)
+	return server, api
+}
+
+// signed Returns a.cram of SRR1 as signed by api.
+func signed(t *testing.T, api *sdl.SDL) fuseralib.File {
+	t.Helper()
+	acc, err := api.Sign("SRR1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := acc.Files["a.cram"]
+	if !ok {
+		t.Fatalf("SRR1 has no a.cram: %s", acc.ErrorLog())
+	}
+	return f
+}
+
+func readAll(t *testing.T, body io.ReadCloser) []byte {
+	t.Helper()
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("couldn't read body: %v", err)
+	}
+	return data
+}
+
+func TestRenewsLinkPastExpirationDate(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	server.Store.LinkTTL = -time.Hour
+	f := signed(t, api)
+	server.Store.LinkTTL = time.Hour
+
+	body, err := fuseralib.NewReader(api, "", "").OpenRange("SRR1", &f, 16)
+	if err != nil {
+		t.Fatalf("couldn't open a.cram: %v", err)
+	}
+	if data := readAll(t, body); !bytes.Equal(data, cram[16:]) {
+		t.Errorf("read %d bytes, want the %d past offset 16", len(data), len(cram)-16)
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("SDL API was asked %d times, want 2: to sign and to renew", n)
+	}
+	if time.Until(f.ExpirationDate) < time.Minute {
+		t.Errorf("expiration date = %v, want the renewed link's", f.ExpirationDate)
+	}
+}
+
+func TestRenewsLinkRefusedAsExpired(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	f := signed(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.Expired)
+
+	body, err := fuseralib.NewReader(api, "", "").OpenRange("SRR1", &f, 0)
+	if err != nil {
+		t.Fatalf("couldn't open a.cram: %v", err)
+	}
+	if data := readAll(t, body); !bytes.Equal(data, cram) {
+		t.Errorf("read %d bytes, want all %d", len(data), len(cram))
+	}
+	if n := server.Store.Gets("SRR1/a.cram"); n != 2 {
+		t.Errorf("a.cram was got %d times, want 2: refused, then with the renewed link", n)
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("SDL API was asked %d times, want 2: to sign and to renew", n)
+	}
+}
+
+func TestForbiddenLinkIsRenewedOnlyOnce(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	f := signed(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.Forbidden, sdltest.Forbidden)
+
+	_, err := fuseralib.NewReader(api, "", "").OpenRange("SRR1", &f, 0)
+	if errors.Cause(err) != syscall.EACCES {
+		t.Fatalf("err = %v, want EACCES", err)
+	}
+	if n := server.Store.Gets("SRR1/a.cram"); n != 2 {
+		t.Errorf("a.cram was got %d times, want 2", n)
+	}
+}
+
+func TestServerErrorIsEAGAIN(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	f := signed(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.ServerError)
+
+	_, err := fuseralib.NewReader(api, "", "").OpenRange("SRR1", &f, 0)
+	if errors.Cause(err) != syscall.EAGAIN {
+		t.Fatalf("err = %v, want EAGAIN", err)
+	}
+}
+
+func TestTruncatedBodyIsAnError(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	f := signed(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.Truncated)
+
+	body, err := fuseralib.NewReader(api, "", "").OpenRange("SRR1", &f, 0)
+	if err != nil {
+		t.Fatalf("couldn't open a.cram: %v", err)
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v after %d bytes, want io.ErrUnexpectedEOF", err, len(data))
+	}
+	if len(data) >= len(cram) {
+		t.Errorf("read %d bytes, want fewer than %d", len(data), len(cram))
+	}
+}
+
+func TestReadsRequesterPaysFileFromEndpoint(t *testing.T) {
+	server := sdltest.NewServer()
+	defer server.Close()
+	server.Store.RequesterPays = true
+	if err := server.AddAccession("SRR1", sdltest.File{Name: "a.cram", Type: "cram", Data: cram, PayRequired: true}); err != nil {
+		t.Fatal(err)
+	}
+	location, err := gps.NewManualLocation("s3.us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags.Silent = true
+	info.LoadAccessionMap([]string{"SRR1"})
+	api := sdl.NewSDL()
+	api.URL = server.URL()
+	api.Param = sdl.NewParam([]string{"SRR1"}, location, nil, "", nil)
+	f := signed(t, api)
+	if !f.PayRequired {
+		t.Fatal("a.cram isn't requester pays")
+	}
+
+	r := fuseralib.NewReader(api, "", "")
+	r.S3Endpoint = server.Store.BaseURL
+	r.S3Credentials = credentials.NewStaticCredentials("id", "secret", "")
+	body, err := r.OpenRange("SRR1", &f, 16)
+	if err != nil {
+		t.Fatalf("couldn't open a.cram: %v", err)
+	}
+	if data := readAll(t, body); !bytes.Equal(data, cram[16:]) {
+		t.Errorf("read %d bytes, want the %d past offset 16", len(data), len(cram)-16)
+	}
+	if n := server.Store.Gets("SRR1/a.cram"); n != 1 {
+		t.Errorf("a.cram was got %d times, want once from the endpoint", n)
+	}
+}
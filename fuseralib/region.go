@@ -0,0 +1,168 @@
+package fuseralib
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/mitre/fusera/flags"
+	"github.com/pkg/errors"
+)
+
+// RegionPolicy What to do about files stored outside the cloud and region Fusera is running in,
+// which cost egress charges to read.
+type RegionPolicy string
+
+const (
+	// RegionAllow Reads files wherever they are.
+	RegionAllow RegionPolicy = "allow"
+	// RegionWarn Reads files wherever they are, warning about those outside the region.
+	RegionWarn RegionPolicy = "warn"
+	// RegionDeny Hides files outside the region and refuses to read them.
+	RegionDeny RegionPolicy = "deny"
+)
+
+// ParseRegionPolicy Returns the RegionPolicy named by policy.
+func ParseRegionPolicy(policy string) (RegionPolicy, error) {
+	switch p := RegionPolicy(strings.ToLower(policy)); p {
+	case RegionAllow, RegionWarn, RegionDeny:
+		return p, nil
+	}
+	return "", errors.Errorf("region policy must be one of: allow, warn, deny, got: %s", policy)
+}
+
+// Mismatch A file stored outside the cloud or region Fusera is running in.
+type Mismatch struct {
+	Accession string
+	File      File
+	// Reason Why the file doesn't match, naming where it is and the location.
+	Reason string
+}
+
+// RegionGuard Compares where files are stored with where Fusera is running, and applies a RegionPolicy to those that differ.
+// A nil RegionGuard allows everything.
+type RegionGuard struct {
+	Policy RegionPolicy
+	// Cloud and Region Where Fusera is running, such as s3 and us-east-1.
+	Cloud  string
+	Region string
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+// NewRegionGuard Returns a RegionGuard applying policy to files outside region of cloud.
+func NewRegionGuard(policy RegionPolicy, cloud, region string) *RegionGuard {
+	return &RegionGuard{
+		Policy: policy,
+		Cloud:  cloud,
+		Region: region,
+		warned: make(map[string]bool),
+	}
+}
+
+// mismatch Returns why f isn't stored where Fusera is running, or "" if it is or either is unknown.
+func (g *RegionGuard) mismatch(f File) string {
+	if g.Cloud == "" || f.Service == "" {
+		return ""
+	}
+	if f.Service != g.Cloud {
+		return fmt.Sprintf("it's stored in %s.%s, a different cloud from the location %s.%s", f.Service, f.Region, g.Cloud, g.Region)
+	}
+	if g.Region == "" || f.Region == "" || sameRegion(g.Cloud, g.Region, f.Region) {
+		return ""
+	}
+	return fmt.Sprintf("it's stored in %s.%s, a different region from the location %s.%s", f.Service, f.Region, g.Cloud, g.Region)
+}
+
+// gcsMultiRegions The regions inside each Google Cloud Storage multi-region, by the prefix of their names.
+var gcsMultiRegions = map[string]string{
+	"us":   "us-",
+	"eu":   "europe-",
+	"asia": "asia-",
+}
+
+// sameRegion Whether a and b, regions of cloud, are the same place as far as egress charges go.
+func sameRegion(cloud, a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if cloud != "gs" {
+		return a == b
+	}
+	// GCP instances know their zone, such as us-central1-a, which is in region us-central1.
+	a, b = gcpRegion(a), gcpRegion(b)
+	if a == b {
+		return true
+	}
+	if prefix, ok := gcsMultiRegions[a]; ok && strings.HasPrefix(b, prefix) {
+		return true
+	}
+	if prefix, ok := gcsMultiRegions[b]; ok && strings.HasPrefix(a, prefix) {
+		return true
+	}
+	return false
+}
+
+func gcpRegion(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 && len(zone)-i == 2 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// Filter Returns a Mismatch for every file of accs stored outside the region.
+// When the policy is deny, those files are also removed from their accessions,
+// and when it's warn, a warning is printed for each instead.
+func (g *RegionGuard) Filter(accs []*Accession) []Mismatch {
+	if g == nil || g.Policy == RegionAllow {
+		return nil
+	}
+	var mismatches []Mismatch
+	for _, a := range accs {
+		for name, f := range a.Files {
+			reason := g.mismatch(f)
+			if reason == "" {
+				continue
+			}
+			mismatches = append(mismatches, Mismatch{Accession: a.ID, File: f, Reason: reason})
+			if g.Policy == RegionDeny {
+				delete(a.Files, name)
+			} else {
+				g.warn(a.ID, f, reason)
+			}
+		}
+	}
+	return mismatches
+}
+
+// Check Returns an error wrapping EACCES if the policy is deny and f, a file of acc, is stored outside the region.
+// When the policy is warn, it prints a warning instead, unless one was already printed for f.
+func (g *RegionGuard) Check(acc string, f File) error {
+	if g == nil || g.Policy == RegionAllow {
+		return nil
+	}
+	reason := g.mismatch(f)
+	if reason == "" {
+		return nil
+	}
+	if g.Policy == RegionDeny {
+		return errors.Wrapf(syscall.EACCES, "region policy denies reading %s/%s: %s", acc, f.Name, reason)
+	}
+	g.warn(acc, f, reason)
+	return nil
+}
+
+// warn Prints to stderr that reading f, a file of acc, may cost egress charges, unless it already has.
+func (g *RegionGuard) warn(acc string, f File, reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := acc + "/" + f.Name
+	if g.warned[key] {
+		return
+	}
+	g.warned[key] = true
+	if !flags.Silent {
+		fmt.Fprintf(os.Stderr, "WARNING: reading %s may cost egress charges: %s\n", key, reason)
+	}
+}
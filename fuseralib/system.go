@@ -26,6 +26,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/mattrbianchi/twig"
 	"github.com/mitre/fusera/awsutil"
 	"github.com/pkg/errors"
 
@@ -41,6 +42,12 @@ type Options struct {
 	Acc          []*Accession
 	Region       string
 	CloudProfile string
+	// RegionGuard What to do about files outside Region, nil to read them all.
+	RegionGuard *RegionGuard
+	// Budget Caps how many bytes are read, nil for no cap.
+	Budget *Budget
+	// AuditLog Records every file opened and closed, nil to record nothing.
+	AuditLog *AuditLog
 
 	// File system
 	MountOptions      map[string]string
@@ -80,7 +87,9 @@ func Mount(ctx context.Context, opt *Options) (*Fusera, *fuse.MountedFileSystem,
 
 func NewFusera(ctx context.Context, opt *Options) (*Fusera, error) {
 	fs := &Fusera{
-		signer:   opt.API,
+		reader:   &Reader{API: opt.API, Region: opt.Region, Profile: opt.CloudProfile, Guard: opt.RegionGuard},
+		budget:   opt.Budget,
+		audit:    opt.AuditLog,
 		accs:     opt.Acc,
 		opt:      opt,
 		DirMode:  0555,
@@ -133,12 +142,11 @@ func NewFusera(ctx context.Context, opt *Options) (*Fusera, error) {
 			dir.mu.Lock()
 			file := NewInode(fs, dir, awsutil.String(name), &fullFileName)
 			file.Link = f.Link
-			if f.PayRequired {
-				file.ReqPays = f.PayRequired
-				file.Region = opt.Region
-				file.Bucket = f.Bucket
-				file.Key = f.Key
-			}
+			file.Service = f.Service
+			file.Region = f.Region
+			file.ReqPays = f.PayRequired
+			file.Bucket = f.Bucket
+			file.Key = f.Key
 			file.CeRequired = f.CeRequired
 			file.Acc = acc.ID
 			file.Attributes = InodeAttributes{
@@ -233,7 +241,9 @@ type Fusera struct {
 	// Fusera specific info
 	accs   []*Accession
 	opt    *Options
-	signer API
+	reader *Reader
+	budget *Budget
+	audit  *AuditLog
 	umask  uint32
 
 	DirMode    os.FileMode
@@ -563,9 +573,19 @@ func (fs *Fusera) OpenFile(ctx context.Context, op *fuseops.OpenFileOp) (err err
 	fs.mu.Unlock()
 
 	fh, err := in.OpenFile()
+	if in.audited() {
+		if auditErr := fs.audit.RecordOpen(op.OpContext.Uid, op.OpContext.Pid, in.Acc, *in.Name, err); auditErr != nil {
+			twig.Infof("refusing to open %s/%s: %s", in.Acc, *in.Name, auditErr.Error())
+			if err == nil {
+				fh.Release()
+			}
+			return syscall.EIO
+		}
+	}
 	if err != nil {
 		return
 	}
+	fh.uid, fh.pid = op.OpContext.Uid, op.OpContext.Pid
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -601,6 +621,9 @@ func (fs *Fusera) ReleaseFileHandle(ctx context.Context, op *fuseops.ReleaseFile
 
 	fh := fs.fileHandles[op.Handle]
 	fh.Release()
+	if fh.inode.audited() {
+		fs.audit.RecordClose(fh.uid, fh.pid, fh.inode.Acc, *fh.inode.Name, fh.opened, fh.bytesRead, fh.readErr)
+	}
 
 	delete(fs.fileHandles, op.Handle)
 	return
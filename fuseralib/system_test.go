@@ -0,0 +1,119 @@
+package fuseralib_test
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/mitre/fusera/mock/sdltest"
+)
+
+// mount Returns a file system of the accessions api signs, and the handle of SRR1/a.cram opened on it.
+// Nothing is actually mounted: operations are called directly, as the kernel would.
+func mount(t *testing.T, api fuseralib.API) (*fuseralib.Fusera, fuseops.HandleID) {
+	t.Helper()
+	fs := newFusera(t, &fuseralib.Options{API: api})
+	return fs, open(t, fs, fuseops.OpContext{})
+}
+
+// newFusera Returns a file system made with opt, of the accessions its API signs.
+func newFusera(t *testing.T, opt *fuseralib.Options) *fuseralib.Fusera {
+	t.Helper()
+	accs, err := opt.API.SignAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.Acc = accs
+	fs, err := fuseralib.NewFusera(context.Background(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// open Returns the handle of SRR1/a.cram opened on fs by the process described by caller.
+func open(t *testing.T, fs *fuseralib.Fusera, caller fuseops.OpContext) fuseops.HandleID {
+	t.Helper()
+	op := &fuseops.OpenFileOp{Inode: lookUp(t, fs), OpContext: caller}
+	if err := fs.OpenFile(context.Background(), op); err != nil {
+		t.Fatalf("couldn't open SRR1/a.cram: %v", err)
+	}
+	return op.Handle
+}
+
+// lookUp Returns the inode of SRR1/a.cram on fs.
+func lookUp(t *testing.T, fs *fuseralib.Fusera) fuseops.InodeID {
+	t.Helper()
+	parent := fuseops.InodeID(fuseops.RootInodeID)
+	for _, name := range []string{"SRR1", "a.cram"} {
+		op := &fuseops.LookUpInodeOp{Parent: parent, Name: name}
+		if err := fs.LookUpInode(context.Background(), op); err != nil {
+			t.Fatalf("couldn't look up %s: %v", name, err)
+		}
+		parent = op.Entry.Child
+	}
+	return parent
+}
+
+func read(fs *fuseralib.Fusera, handle fuseops.HandleID, offset int64, n int) ([]byte, error) {
+	op := &fuseops.ReadFileOp{Handle: handle, Offset: offset, Dst: make([]byte, n)}
+	err := fs.ReadFile(context.Background(), op)
+	return op.Dst[:op.BytesRead], err
+}
+
+func TestReadPicksUpAfterTruncatedBody(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	fs, handle := mount(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.Truncated)
+
+	data, err := read(fs, handle, 0, len(cram))
+	if err != nil {
+		t.Fatalf("couldn't read a.cram: %v", err)
+	}
+	if !bytes.Equal(data, cram) {
+		t.Errorf("read %d bytes, want all %d", len(data), len(cram))
+	}
+	if n := server.Store.Gets("SRR1/a.cram"); n != 2 {
+		t.Errorf("a.cram was got %d times, want 2: cut short, then from where it was cut", n)
+	}
+}
+
+func TestReadAfterServerErrorTriesAgain(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	fs, handle := mount(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.ServerError)
+
+	if _, err := read(fs, handle, 0, 4096); err != syscall.EAGAIN {
+		t.Fatalf("err = %v, want EAGAIN", err)
+	}
+	data, err := read(fs, handle, 0, 4096)
+	if err != nil {
+		t.Fatalf("couldn't read a.cram after the server error: %v", err)
+	}
+	if !bytes.Equal(data, cram[:4096]) {
+		t.Errorf("read %d bytes, want the first 4096", len(data))
+	}
+}
+
+func TestReadRenewsLinkRefusedAsExpired(t *testing.T) {
+	server, api := serve(t)
+	defer server.Close()
+	fs, handle := mount(t, api)
+	server.Store.Fail("SRR1/a.cram", sdltest.Expired)
+
+	data, err := read(fs, handle, 4096, 4096)
+	if err != nil {
+		t.Fatalf("couldn't read a.cram: %v", err)
+	}
+	if !bytes.Equal(data, cram[4096:8192]) {
+		t.Errorf("read %d bytes, want bytes 4096 to 8192", len(data))
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("SDL API was asked %d times, want 2: to mount and to renew", n)
+	}
+}
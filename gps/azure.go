@@ -0,0 +1,94 @@
+package gps
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const azureAPIVersion = "2020-09-01"
+
+// AzureLocation A location for Azure environment.
+type AzureLocation struct{}
+
+// SdlCloudName Returns azure, the proper string SDL associates with Azure.
+func (a *AzureLocation) SdlCloudName() string {
+	return "azure"
+}
+
+// Region Returns the sublocation of the cloud platform the current server is running on.
+func (a *AzureLocation) Region() (string, error) {
+	region, err := resolveAzureRegion(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return region, nil
+}
+
+// Locality Returns the locality for Azure environment, the signature of the instance's attested data.
+func (a *AzureLocation) Locality() (string, error) {
+	token, err := retrieveAzureAttestedData(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// LocalityType Returns the locality-type for Azure environment.
+func (a *AzureLocation) LocalityType() string {
+	return "azure_pkcs7"
+}
+
+// getAzureMetadata Returns the body of the metadata at path from the Azure Instance Metadata Service.
+func getAzureMetadata(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	query.Set("api-version", azureAPIVersion)
+	req, err := http.NewRequest("GET", AzureMetadataURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "INTERNAL ERROR: couldn't create request for %s", path)
+	}
+	// Azure refuses requests without this header, so they can't be made by a redirected browser.
+	req.Header.Set("Metadata", "true")
+	resp, err := newMetadataClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "location was not provided, fusera attempted to resolve region but encountered an error, this feature only works when fusera is on an amazon, google, or azure instance")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("got: %d: %s", resp.StatusCode, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func resolveAzureRegion(ctx context.Context) (string, error) {
+	region, err := getAzureMetadata(ctx, "/instance/compute/location", url.Values{"format": {"text"}})
+	if err != nil {
+		return "", errors.Wrap(err, "issue trying to resolve region")
+	}
+	if strings.TrimSpace(string(region)) == "" {
+		return "", errors.New("issue trying to resolve region, azure returned empty region")
+	}
+	return strings.TrimSpace(string(region)), nil
+}
+
+func retrieveAzureAttestedData(ctx context.Context) ([]byte, error) {
+	data, err := getAzureMetadata(ctx, "/attested/document", url.Values{})
+	if err != nil {
+		return nil, errors.Wrap(err, "issue trying to retrieve Azure attested data")
+	}
+	var payload struct {
+		Encoding  string `json:"encoding"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, errors.New("issue trying to retrieve Azure attested data, couldn't decode response from azure")
+	}
+	if payload.Signature == "" {
+		return nil, errors.New("issue trying to retrieve Azure attested data, azure returned an empty signature")
+	}
+	return []byte(payload.Signature), nil
+}
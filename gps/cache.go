@@ -0,0 +1,83 @@
+package gps
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// LocalityTTL How long a locality is reused when it doesn't say when it expires.
+	LocalityTTL = 10 * time.Minute
+	// localityRenewalWindow How long before a locality expires that it's fetched again.
+	localityRenewalWindow = time.Minute
+)
+
+// CachedLocator A Locator that remembers its region, and its locality until it nears expiry,
+// so reads that need an identity token don't ask the metadata service for one every time.
+type CachedLocator struct {
+	Locator
+
+	mu       sync.Mutex
+	region   string
+	locality string
+	expires  time.Time
+}
+
+// NewCachedLocator Returns a CachedLocator in front of l.
+func NewCachedLocator(l Locator) *CachedLocator {
+	return &CachedLocator{Locator: l}
+}
+
+// Region Returns the region of the wrapped Locator, which is only asked for once it's answered.
+func (c *CachedLocator) Region() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.region != "" {
+		return c.region, nil
+	}
+	region, err := c.Locator.Region()
+	if err != nil {
+		return "", err
+	}
+	c.region = region
+	return region, nil
+}
+
+// Locality Returns the locality of the wrapped Locator, asking it again only when the last one is about to expire.
+func (c *CachedLocator) Locality() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.locality != "" && time.Until(c.expires) > localityRenewalWindow {
+		return c.locality, nil
+	}
+	locality, err := c.Locator.Locality()
+	if err != nil {
+		return "", err
+	}
+	c.locality = locality
+	c.expires = localityExpiry(locality, time.Now())
+	return locality, nil
+}
+
+// localityExpiry Returns when locality expires: the exp claim of a JWT, such as GCP's identity token,
+// otherwise LocalityTTL after now.
+func localityExpiry(locality string, now time.Time) time.Time {
+	parts := strings.Split(locality, ".")
+	if len(parts) != 3 {
+		return now.Add(LocalityTTL)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return now.Add(LocalityTTL)
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return now.Add(LocalityTTL)
+	}
+	return time.Unix(claims.Exp, 0)
+}
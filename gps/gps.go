@@ -1,13 +1,16 @@
 package gps
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -31,7 +34,7 @@ func (g *GcpLocation) SdlCloudName() string {
 
 // Region Returns the sublocation of the cloud platform the current server is running on.
 func (g *GcpLocation) Region() (string, error) {
-	region, err := resolveGcpZone()
+	region, err := resolveGcpZone(context.Background())
 	if err != nil {
 		return "", err
 	}
@@ -40,7 +43,7 @@ func (g *GcpLocation) Region() (string, error) {
 
 // Locality Returns the locality for GCP environment.
 func (g *GcpLocation) Locality() (string, error) {
-	token, err := retrieveGCPInstanceToken()
+	token, err := retrieveGCPInstanceToken(context.Background())
 	if err != nil {
 		return "", err
 	}
@@ -52,8 +55,16 @@ func (g *GcpLocation) LocalityType() string {
 	return "gcp_jwt"
 }
 
+// AllowImdsV1 Whether the AwsLocation returned by GenerateLocator may fall back to IMDSv1
+// when it can't get an IMDSv2 session token.
+var AllowImdsV1 bool
+
 // AwsLocation A location for AWS environment.
-type AwsLocation struct{}
+type AwsLocation struct {
+	// AllowV1 Fall back to IMDSv1 when the instance metadata service won't give out an IMDSv2 session token.
+	// Instances that require IMDSv2 refuse IMDSv1 requests, so this is only needed for old or unusual setups.
+	AllowV1 bool
+}
 
 // SdlCloudName Returns s3, the proper string SDL associates with AWS.
 func (a *AwsLocation) SdlCloudName() string {
@@ -62,16 +73,16 @@ func (a *AwsLocation) SdlCloudName() string {
 
 // Region Returns the sublocation of the cloud platform the current server is running on.
 func (a *AwsLocation) Region() (string, error) {
-	region, err := resolveAwsRegion()
+	region, err := resolveAwsRegion(context.Background(), a.AllowV1)
 	if err != nil {
 		return "", err
 	}
 	return region, nil
 }
 
-// Locality Returns the locality for AWS environment. //TODO: Implement
+// Locality Returns the locality for AWS environment.
 func (a *AwsLocation) Locality() (string, error) {
-	token, err := retrieveAWSInstanceToken()
+	token, err := retrieveAWSInstanceToken(context.Background(), a.AllowV1)
 	if err != nil {
 		return "", err
 	}
@@ -85,22 +96,23 @@ func (a *AwsLocation) LocalityType() string {
 
 // ManualLocation A location for a manual environment.
 type ManualLocation struct {
-	locality string
+	cloud  string
+	region string
 }
 
-// SdlCloudName Returns whatever it was given as the cloud name.
+// SdlCloudName Returns the cloud it was given, such as s3.
 func (m *ManualLocation) SdlCloudName() string {
-	return m.locality
+	return m.cloud
 }
 
-// Region Returns the sublocation of the cloud platform the current server is running on.
+// Region Returns the region it was given, such as us-east-1.
 func (m *ManualLocation) Region() (string, error) {
-	return m.locality, nil
+	return m.region, nil
 }
 
-// Locality Returns the locality for a manual environment.
+// Locality Returns the locality for a manual environment, in the cloud.region form the SDL API expects.
 func (m *ManualLocation) Locality() (string, error) {
-	return m.locality, nil
+	return m.cloud + "." + m.region, nil
 }
 
 // LocalityType Returns the locality-type "forced" for a manual environment.
@@ -108,30 +120,82 @@ func (m *ManualLocation) LocalityType() string {
 	return "forced"
 }
 
-// NewManualLocation Returns a new manual location with the provided platform.
+// NewManualLocation Returns a new manual location parsed from location, which must look like cloud.region,
+// as in s3.us-east-1, gs.US, or azure.eastus.
+// A region the cloud isn't known to have is only warned about, since clouds open new regions faster than knownRegions is updated.
 func NewManualLocation(location string) (*ManualLocation, error) {
-	return &ManualLocation{locality: location}, nil
+	parts := strings.SplitN(strings.TrimSpace(location), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("location must look like cloud.region, as in s3.us-east-1 or gs.US, got: %q", location)
+	}
+	cloud, region := parts[0], parts[1]
+	regions, ok := knownRegions[cloud]
+	if !ok {
+		return nil, errors.Errorf("location %q has an unknown cloud: %q, must be one of: %s", location, cloud, strings.Join(knownClouds(), ", "))
+	}
+	if !regions.has(region) {
+		fmt.Fprintf(os.Stderr, "WARNING: location %q has a region %s isn't known to have: %q, using it anyway. Check it's spelled right if files can't be found.\n", location, cloud, region)
+	}
+	return &ManualLocation{cloud: cloud, region: region}, nil
 }
 
+// DetectTimeout How long GenerateLocator waits for a cloud's metadata service to answer.
+var DetectTimeout = 2 * time.Second
+
 // GenerateLocator Determines which locator to use by attempting to detect what cloud platform it is running on.
+// Every cloud is probed at once, and none are waited on for longer than DetectTimeout.
 func GenerateLocator() (Locator, error) {
-	_, err := resolveAwsRegion()
-	if err != nil {
-		// could be on google
-		// retain aws error message
-		msg := err.Error()
-		_, err := retrieveGCPInstanceToken()
-		if err != nil {
-			// return both aws and google error messages
-			return nil, errors.Wrap(err, msg)
+	ctx, cancel := context.WithTimeout(context.Background(), DetectTimeout)
+	defer cancel()
+	return DetectLocator(ctx)
+}
+
+// DetectLocator Probes the metadata service of every cloud platform at once until ctx is done,
+// returning a CachedLocator for the first one to answer with a region.
+func DetectLocator(ctx context.Context) (Locator, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// Stop the probes still waiting once one cloud has answered.
+	defer cancel()
+	probes := []struct {
+		cloud   string
+		locator Locator
+		region  func(context.Context) (string, error)
+	}{
+		{"aws", &AwsLocation{AllowV1: AllowImdsV1}, func(ctx context.Context) (string, error) { return resolveAwsRegion(ctx, AllowImdsV1) }},
+		{"gcp", &GcpLocation{}, resolveGcpZone},
+		{"azure", &AzureLocation{}, resolveAzureRegion},
+	}
+	type answer struct {
+		probe  int
+		region string
+		err    error
+	}
+	answers := make(chan answer, len(probes))
+	for i := range probes {
+		go func(i int) {
+			region, err := probes[i].region(ctx)
+			answers <- answer{probe: i, region: region, err: err}
+		}(i)
+	}
+	errs := make([]string, len(probes))
+	for range probes {
+		a := <-answers
+		if a.err == nil {
+			c := NewCachedLocator(probes[a.probe].locator)
+			c.region = a.region
+			return c, nil
 		}
-		return &GcpLocation{}, nil
+		errs[a.probe] = probes[a.probe].cloud + ": " + a.err.Error()
 	}
-	return &AwsLocation{}, nil
+	// return every cloud's error message
+	return nil, errors.New(strings.Join(errs, "\n"))
 }
 
-func resolveAwsRegion() (string, error) {
-	client := &http.Client{
+// newMetadataClient Returns a client that gives up quickly, so detecting the cloud doesn't hang when it's not there.
+func newMetadataClient() *http.Client {
+	return &http.Client{
+		// Metadata services are on the instance's own network, an answer that takes longer isn't coming.
+		Timeout: 5 * time.Second,
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
@@ -146,20 +210,85 @@ func resolveAwsRegion() (string, error) {
 			ExpectContinueTimeout: 500 * time.Millisecond,
 		},
 	}
+}
+
+// awsTokenTTL How long an IMDSv2 session token lasts, in seconds. Each lookup gets its own, so it needn't last long.
+const awsTokenTTL = "60"
+
+// awsMetadata Reads from the AWS instance metadata service, sending an IMDSv2 session token with every request.
+// Without a token, requests are IMDSv1.
+type awsMetadata struct {
+	ctx    context.Context
+	client *http.Client
+	token  string
+}
+
+// newAwsMetadata Gets an IMDSv2 session token with a PUT to /latest/api/token.
+// If that fails and allowV1 is set, requests are made without one.
+func newAwsMetadata(ctx context.Context, allowV1 bool) (*awsMetadata, error) {
+	m := &awsMetadata{ctx: ctx, client: newMetadataClient()}
+	req, err := http.NewRequest("PUT", AwsMetadataURL+"/api/token", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "INTERNAL ERROR: couldn't create request for an IMDSv2 session token")
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", awsTokenTTL)
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if allowV1 {
+			// The response to a PUT can be dropped by a hop limit that GETs get through, as in containers.
+			return m, nil
+		}
+		return nil, errors.Wrapf(err, "location was not provided, fusera attempted to resolve region but encountered an error, this feature only works when fusera is on an amazon, google, or azure instance")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		token, err := ioutil.ReadAll(resp.Body)
+		if err == nil && len(token) > 0 {
+			m.token = string(token)
+			return m, nil
+		}
+	}
+	if !allowV1 {
+		return nil, errors.Errorf("issue trying to get an IMDSv2 session token from amazon, got: %d: %s, and falling back to IMDSv1 isn't allowed", resp.StatusCode, resp.Status)
+	}
+	return m, nil
+}
+
+// get Returns the body of the metadata at path, such as /dynamic/instance-identity/document.
+func (m *awsMetadata) get(path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", AwsMetadataURL+path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "INTERNAL ERROR: couldn't create request for %s", path)
+	}
+	if m.token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", m.token)
+	}
+	resp, err := m.client.Do(req.WithContext(m.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("got: %d: %s", resp.StatusCode, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func resolveAwsRegion(ctx context.Context, allowV1 bool) (string, error) {
 	// maybe we are on an AWS instance and can resolve what region we are in.
 	// let's try it out and if we timeout we'll return an error.
-	// use this url: http://169.254.169.254/latest/dynamic/instance-identity/document
-	resp, err := client.Get("http://169.254.169.254/latest/dynamic/instance-identity/document")
+	m, err := newAwsMetadata(ctx, allowV1)
 	if err != nil {
-		return "", errors.Wrapf(err, "location was not provided, fusera attempted to resolve region but encountered an error, this feature only works when fusera is on an amazon or google instance")
+		return "", err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.Errorf("issue trying to resolve region, got: %d: %s", resp.StatusCode, resp.Status)
+	document, err := m.get("/dynamic/instance-identity/document")
+	if err != nil {
+		return "", errors.Wrap(err, "issue trying to resolve region")
 	}
 	var payload struct {
 		Region string `json:"region"`
 	}
-	err = json.NewDecoder(resp.Body).Decode(&payload)
+	err = json.Unmarshal(document, &payload)
 	if err != nil {
 		return "", errors.New("issue trying to resolve region, couldn't decode response from amazon")
 	}
@@ -169,28 +298,15 @@ func resolveAwsRegion() (string, error) {
 	return payload.Region, nil
 }
 
-func resolveGcpZone() (string, error) {
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   1 * time.Second,
-				KeepAlive: 1 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          1000,
-			MaxIdleConnsPerHost:   1000,
-			IdleConnTimeout:       500 * time.Millisecond,
-			TLSHandshakeTimeout:   500 * time.Millisecond,
-			ExpectContinueTimeout: 500 * time.Millisecond,
-		},
-	}
-	req, err := http.NewRequest("GET", "http://metadata.google.internal/computeMetadata/v1/instance/zone?alt=json", nil)
+func resolveGcpZone(ctx context.Context) (string, error) {
+	client := newMetadataClient()
+	req, err := http.NewRequest("GET", GcpMetadataURL+"/instance/zone?alt=json", nil)
 	req.Header.Add("Metadata-Flavor", "Google")
-	resp, err := client.Do(req)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return "", errors.Wrapf(err, "location was not provided, fusera attempted to resolve region but encountered an error, this feature only works when fusera is on an amazon or google instance")
+		return "", errors.Wrapf(err, "location was not provided, fusera attempted to resolve region but encountered an error, this feature only works when fusera is on an amazon, google, or azure instance")
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return "", errors.Errorf("issue trying to resolve region, got: %d: %s", resp.StatusCode, resp.Status)
 	}
@@ -206,29 +322,16 @@ func resolveGcpZone() (string, error) {
 	return path, nil
 }
 
-func retrieveGCPInstanceToken() ([]byte, error) {
+func retrieveGCPInstanceToken(ctx context.Context) ([]byte, error) {
 	// make a request to token endpoint
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   1 * time.Second,
-				KeepAlive: 1 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          1000,
-			MaxIdleConnsPerHost:   1000,
-			IdleConnTimeout:       500 * time.Millisecond,
-			TLSHandshakeTimeout:   500 * time.Millisecond,
-			ExpectContinueTimeout: 500 * time.Millisecond,
-		},
-	}
-	req, err := http.NewRequest("GET", "http://metadata/computeMetadata/v1/instance/service-accounts/default/identity?audience=https://www.ncbi.nlm.nih.gov&format=full", nil)
+	client := newMetadataClient()
+	req, err := http.NewRequest("GET", GcpMetadataURL+"/instance/service-accounts/default/identity?audience=https://www.ncbi.nlm.nih.gov&format=full", nil)
 	req.Header.Add("Metadata-Flavor", "Google")
-	resp, err := client.Do(req)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, errors.Wrapf(err, "fusera attempted to retrieve an instance token but encountered an error, this feature only works when fusera is on an amazon or google instance")
+		return nil, errors.Wrapf(err, "fusera attempted to retrieve an instance token but encountered an error, this feature only works when fusera is on an amazon, google, or azure instance")
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.Errorf("issue trying to retreive GCP instance token, got: %d: %s", resp.StatusCode, resp.Status)
 	}
@@ -239,41 +342,18 @@ func retrieveGCPInstanceToken() ([]byte, error) {
 	return token, nil
 }
 
-func retrieveAWSInstanceToken() ([]byte, error) {
-	// make a request to token endpoint
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   1 * time.Second,
-				KeepAlive: 1 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          1000,
-			MaxIdleConnsPerHost:   1000,
-			IdleConnTimeout:       500 * time.Millisecond,
-			TLSHandshakeTimeout:   500 * time.Millisecond,
-			ExpectContinueTimeout: 500 * time.Millisecond,
-		},
-	}
-	resp, err := client.Get("http://169.254.169.254/latest/dynamic/instance-identity/pkcs7")
-	if err != nil {
-		return nil, errors.Wrapf(err, "fusera attempted to retrieve an instance token but encountered an error, this feature only works when fusera is on an amazon or google instance")
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("issue trying to retreive AWS instance token, got: %d: %s", resp.StatusCode, resp.Status)
-	}
-	token, err := ioutil.ReadAll(resp.Body)
+func retrieveAWSInstanceToken(ctx context.Context, allowV1 bool) ([]byte, error) {
+	m, err := newAwsMetadata(ctx, allowV1)
 	if err != nil {
-		return nil, errors.New("issue trying to retrieve an instance token, couldn't decode response from aws")
+		return nil, err
 	}
-	resp, err = client.Get("http://169.254.169.254/latest/dynamic/instance-identity/document")
+	token, err := m.get("/dynamic/instance-identity/pkcs7")
 	if err != nil {
-		return nil, errors.Wrapf(err, "fusera attempted to retrieve the identity document for an instance token but encountered an error, this feature only works when fusera is on an amazon or google instance")
+		return nil, errors.Wrap(err, "issue trying to retreive AWS instance token")
 	}
-	document, err := ioutil.ReadAll(resp.Body)
+	document, err := m.get("/dynamic/instance-identity/document")
 	if err != nil {
-		return nil, errors.New("issue trying to retrieve the identity document for an instance token, couldn't decode response from aws")
+		return nil, errors.Wrap(err, "issue trying to retrieve the identity document for an instance token")
 	}
 	beginPKCS7 := base64.StdEncoding.EncodeToString([]byte("-----BEGIN PKCS7-----\n"))
 	encodedToken := base64.StdEncoding.EncodeToString([]byte(string(token) + "\n"))
@@ -0,0 +1,313 @@
+package gps_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitre/fusera/gps"
+	"github.com/mitre/fusera/mock/metadatatest"
+)
+
+// awsRequests Returns the paths of the requests made to the AWS metadata service, with the method before each.
+func awsRequests(s *metadatatest.Server) []string {
+	var rr []string
+	for _, r := range s.Requests() {
+		if r.Cloud == metadatatest.AWS {
+			rr = append(rr, r.Method+" "+r.Path)
+		}
+	}
+	return rr
+}
+
+func TestManualLocationSplitsCloudAndRegion(t *testing.T) {
+	l, err := gps.NewManualLocation("s3.us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region, _ := l.Region(); l.SdlCloudName() != "s3" || region != "us-east-1" {
+		t.Errorf("location = %s and %s, want s3 and us-east-1", l.SdlCloudName(), region)
+	}
+	for _, bad := range []string{"us-east-1", "s3.", "aws.us-east-1"} {
+		if _, err := gps.NewManualLocation(bad); err == nil {
+			t.Errorf("parsed %q, want an error", bad)
+		}
+	}
+}
+
+func TestManualLocationTakesUnknownRegion(t *testing.T) {
+	l, err := gps.NewManualLocation("s3.us-north-9")
+	if err != nil {
+		t.Fatalf("refused a region newer than the known ones: %v", err)
+	}
+	if region, _ := l.Region(); region != "us-north-9" {
+		t.Errorf("region = %q, want us-north-9", region)
+	}
+}
+
+func TestAwsAsksForIMDSv2Token(t *testing.T) {
+	s := metadatatest.NewAwsServer("us-west-2")
+	defer s.Close()
+	s.RequireIMDSv2 = true
+	defer s.Use()()
+
+	region, err := (&gps.AwsLocation{}).Region()
+	if err != nil {
+		t.Fatalf("couldn't resolve region: %v", err)
+	}
+	if region != "us-west-2" {
+		t.Errorf("region = %q, want us-west-2", region)
+	}
+	rr := s.Requests()
+	if len(rr) != 2 || rr[0].Method != "PUT" || rr[0].Path != "/api/token" {
+		t.Fatalf("requests = %v, want a PUT of /api/token then the identity document", awsRequests(s))
+	}
+	if ttl := rr[0].Header.Get("X-aws-ec2-metadata-token-ttl-seconds"); ttl == "" {
+		t.Error("token PUT didn't say how long the token should last")
+	}
+	if token := rr[1].Header.Get("X-aws-ec2-metadata-token"); token == "" {
+		t.Error("identity document was asked for without the session token")
+	}
+}
+
+func TestAwsLocalityIsSignedIdentity(t *testing.T) {
+	s := metadatatest.NewAwsServer("us-east-1")
+	defer s.Close()
+	s.Identity = "MIIPKCS7"
+	defer s.Use()()
+
+	locality, err := (&gps.AwsLocation{}).Locality()
+	if err != nil {
+		t.Fatalf("couldn't get locality: %v", err)
+	}
+	begin := base64.StdEncoding.EncodeToString([]byte("-----BEGIN PKCS7-----\n"))
+	signature := base64.StdEncoding.EncodeToString([]byte("MIIPKCS7\n"))
+	if !strings.HasPrefix(locality, begin+signature) {
+		t.Errorf("locality = %q, want the pkcs7 signature wrapped in BEGIN and END lines", locality)
+	}
+}
+
+func TestAwsIMDSv1OnlyWhenAllowed(t *testing.T) {
+	s := metadatatest.NewAwsServer("us-east-2")
+	defer s.Close()
+	s.DisableIMDSv2 = true
+	defer s.Use()()
+
+	if _, err := (&gps.AwsLocation{}).Region(); err == nil {
+		t.Fatal("resolved region without a session token, want an error since IMDSv1 isn't allowed")
+	}
+	if rr := awsRequests(s); len(rr) != 1 {
+		t.Errorf("requests = %v, want only the refused token PUT", rr)
+	}
+
+	region, err := (&gps.AwsLocation{AllowV1: true}).Region()
+	if err != nil {
+		t.Fatalf("couldn't resolve region over IMDSv1: %v", err)
+	}
+	if region != "us-east-2" {
+		t.Errorf("region = %q, want us-east-2", region)
+	}
+	rr := s.Requests()
+	if last := rr[len(rr)-1]; last.Path != "/dynamic/instance-identity/document" || last.Header.Get("X-aws-ec2-metadata-token") != "" {
+		t.Errorf("last request = %s %v, want the identity document without a token", last.Path, last.Header)
+	}
+}
+
+func TestDetectLocatorFallsBackToIMDSv1WhenAllowed(t *testing.T) {
+	s := metadatatest.NewAwsServer("eu-west-1")
+	defer s.Close()
+	s.DisableIMDSv2 = true
+	defer s.Use()()
+	defer func(allow bool) { gps.AllowImdsV1 = allow }(gps.AllowImdsV1)
+
+	gps.AllowImdsV1 = false
+	if l, err := gps.DetectLocator(context.Background()); err == nil {
+		t.Fatalf("detected %s, want an error since IMDSv1 isn't allowed", l.SdlCloudName())
+	}
+
+	gps.AllowImdsV1 = true
+	l, err := gps.DetectLocator(context.Background())
+	if err != nil {
+		t.Fatalf("couldn't detect location: %v", err)
+	}
+	if region, _ := l.Region(); l.SdlCloudName() != "s3" || region != "eu-west-1" {
+		t.Errorf("detected %s.%s, want s3.eu-west-1", l.SdlCloudName(), region)
+	}
+}
+
+func TestAzureLocalityIsAttestedDocument(t *testing.T) {
+	s := metadatatest.NewAzureServer("eastus")
+	defer s.Close()
+	s.Identity = "MIIATTESTED"
+	defer s.Use()()
+
+	locality, err := (&gps.AzureLocation{}).Locality()
+	if err != nil {
+		t.Fatalf("couldn't get locality: %v", err)
+	}
+	if locality != "MIIATTESTED" {
+		t.Errorf("locality = %q, want the attested document's signature", locality)
+	}
+	rr := s.Requests()
+	if len(rr) != 1 || rr[0].Path != "/attested/document" || rr[0].Header.Get("Metadata") != "true" {
+		t.Errorf("requests = %v, want one for /attested/document with the Metadata header", rr)
+	}
+}
+
+func TestDetectLocatorFindsEachCloud(t *testing.T) {
+	tests := []struct {
+		cloud, region, sdlName string
+	}{
+		{metadatatest.AWS, "us-east-1", "s3"},
+		{metadatatest.GCP, "us-central1-a", "gs"},
+		{metadatatest.Azure, "westeurope", "azure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cloud, func(t *testing.T) {
+			s := metadatatest.NewServer(tt.cloud, tt.region)
+			defer s.Close()
+			defer s.Use()()
+
+			l, err := gps.DetectLocator(context.Background())
+			if err != nil {
+				t.Fatalf("couldn't detect location: %v", err)
+			}
+			region, err := l.Region()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.SdlCloudName() != tt.sdlName || region != tt.region {
+				t.Errorf("detected %s.%s, want %s.%s", l.SdlCloudName(), region, tt.sdlName, tt.region)
+			}
+		})
+	}
+}
+
+func TestDetectLocatorProbesCloudsAtOnce(t *testing.T) {
+	s := metadatatest.NewGcpServer("us-east1-b")
+	defer s.Close()
+	defer s.Use()()
+	// AWS's metadata service never answers, so GCP is only found in time if it's asked at the same time.
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hang.Close()
+	defer func(url string) { gps.AwsMetadataURL = url }(gps.AwsMetadataURL)
+	gps.AwsMetadataURL = hang.URL + "/latest"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	l, err := gps.DetectLocator(ctx)
+	if err != nil {
+		t.Fatalf("couldn't detect location: %v", err)
+	}
+	if l.SdlCloudName() != "gs" {
+		t.Errorf("detected %s, want gs", l.SdlCloudName())
+	}
+	if took := time.Since(start); took > 400*time.Millisecond {
+		t.Errorf("detecting took %v, want it not to wait on AWS", took)
+	}
+}
+
+func TestGenerateLocatorTimesOut(t *testing.T) {
+	s := metadatatest.NewAwsServer("us-east-1")
+	defer s.Close()
+	s.Delay = time.Minute
+	defer s.Use()()
+	defer func(timeout time.Duration) { gps.DetectTimeout = timeout }(gps.DetectTimeout)
+	gps.DetectTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if l, err := gps.GenerateLocator(); err == nil {
+		t.Fatalf("detected %s from a metadata service that never answers", l.SdlCloudName())
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("gave up after %v, want about %v", took, gps.DetectTimeout)
+	}
+}
+
+// TestEnvPointsAtMetadataService Runs this test binary again with the environment from Env,
+// since the environment variables are only read when gps is first loaded.
+func TestEnvPointsAtMetadataService(t *testing.T) {
+	if os.Getenv("GPS_TEST_DETECT") != "" {
+		l, err := gps.GenerateLocator()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		region, _ := l.Region()
+		fmt.Printf("detected %s.%s\n", l.SdlCloudName(), region)
+		os.Exit(0)
+	}
+	s := metadatatest.NewAzureServer("japaneast")
+	defer s.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEnvPointsAtMetadataService$")
+	cmd.Env = append(append(os.Environ(), "GPS_TEST_DETECT=1"), s.Env()...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("couldn't detect location: %v: %s", err, out)
+	}
+	if !strings.Contains(string(out), "detected azure.japaneast") {
+		t.Errorf("output = %q, want azure.japaneast detected", out)
+	}
+}
+
+func TestWorkloadTokenFileIsReadEveryTime(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(file, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := gps.NewWorkloadLocator("gs.US", file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locality, err := l.Locality(); err != nil || locality != "first" {
+		t.Fatalf("locality = %q, %v, want first", locality, err)
+	}
+	if err := ioutil.WriteFile(file, []byte("rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if locality, err := l.Locality(); err != nil || locality != "rotated" {
+		t.Errorf("locality = %q, %v, want the rotated token", locality, err)
+	}
+	if l.LocalityType() != "gcp_jwt" {
+		t.Errorf("locality type = %q, want gcp_jwt", l.LocalityType())
+	}
+}
+
+func TestWorkloadTokenCommandIsReused(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	l, err := gps.NewWorkloadLocator("gs.us-central1", "", "echo run >> "+count+"; echo token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if locality, err := l.Locality(); err != nil || locality != "token" {
+			t.Fatalf("locality = %q, %v, want token", locality, err)
+		}
+	}
+	runs, err := ioutil.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("token command ran %d times, want once", n)
+	}
+}
+
+func TestWorkloadNeedsLocationOnGs(t *testing.T) {
+	if _, err := gps.NewWorkloadLocator("s3.us-east-1", "/var/run/token", ""); err == nil {
+		t.Error("made a workload locator on s3, want an error since the SDL API only takes tokens issued by google")
+	}
+}
@@ -0,0 +1,27 @@
+package gps
+
+import "os"
+
+// Where each cloud's instance metadata service is found.
+// They can be pointed elsewhere, such as at a fake metadata server in tests or the one a VM emulator provides,
+// by setting them or the environment variable named beside each before locating.
+var (
+	// AwsMetadataURL $DBGAP_AWS_METADATA_URL
+	AwsMetadataURL = "http://169.254.169.254/latest"
+	// GcpMetadataURL $DBGAP_GCP_METADATA_URL
+	GcpMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+	// AzureMetadataURL $DBGAP_AZURE_METADATA_URL
+	AzureMetadataURL = "http://169.254.169.254/metadata"
+)
+
+func init() {
+	overrideFromEnv("DBGAP_AWS_METADATA_URL", &AwsMetadataURL)
+	overrideFromEnv("DBGAP_GCP_METADATA_URL", &GcpMetadataURL)
+	overrideFromEnv("DBGAP_AZURE_METADATA_URL", &AzureMetadataURL)
+}
+
+func overrideFromEnv(name string, url *string) {
+	if value := os.Getenv(name); value != "" {
+		*url = value
+	}
+}
@@ -0,0 +1,112 @@
+package gps
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenCommandTimeout How long a WorkloadLocation waits for its token command.
+var TokenCommandTimeout = 30 * time.Second
+
+// WorkloadLocation A location proven with a workload identity token instead of the instance's identity,
+// for containers and pods whose instance metadata is blocked or belongs to the node,
+// such as the identity token GKE workload identity gives a pod, or one printed by a credential helper.
+// The SDL API only takes identity tokens issued by Google, as gcp_jwt, so the location must be on gs.
+// The cloud and region can't be looked up, so they're given as they are to a ManualLocation.
+type WorkloadLocation struct {
+	cloud  string
+	region string
+	// TokenFile A file holding the token, read again every time it's needed since projected tokens are rotated in place.
+	TokenFile string
+	// TokenCommand A command run with sh whose standard output is the token, used when there's no TokenFile.
+	TokenCommand string
+}
+
+// NewWorkloadLocation Returns a WorkloadLocation in location, which must look like cloud.region as it does for NewManualLocation,
+// proving locality with the token in tokenFile or printed by tokenCommand.
+func NewWorkloadLocation(location, tokenFile, tokenCommand string) (*WorkloadLocation, error) {
+	if tokenFile == "" && tokenCommand == "" {
+		return nil, errors.New("a workload identity needs a token file or a token command")
+	}
+	if location == "" {
+		return nil, errors.New("a workload identity can't look up its location, so a location must be provided along with it")
+	}
+	m, err := NewManualLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if m.cloud != "gs" {
+		return nil, errors.Errorf("the SDL API only takes workload identity tokens issued by google, so location must be on gs, got: %s", location)
+	}
+	return &WorkloadLocation{cloud: m.cloud, region: m.region, TokenFile: tokenFile, TokenCommand: tokenCommand}, nil
+}
+
+// SdlCloudName Returns the cloud it was given, such as s3.
+func (w *WorkloadLocation) SdlCloudName() string {
+	return w.cloud
+}
+
+// Region Returns the region it was given, such as us-east-1.
+func (w *WorkloadLocation) Region() (string, error) {
+	return w.region, nil
+}
+
+// Locality Returns the workload identity token.
+func (w *WorkloadLocation) Locality() (string, error) {
+	var token []byte
+	var err error
+	if w.TokenFile != "" {
+		token, err = ioutil.ReadFile(w.TokenFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "couldn't read workload identity token file: %s", w.TokenFile)
+		}
+	} else {
+		token, err = runTokenCommand(w.TokenCommand)
+		if err != nil {
+			return "", err
+		}
+	}
+	locality := strings.TrimSpace(string(token))
+	if locality == "" {
+		return "", errors.New("workload identity token was empty")
+	}
+	return locality, nil
+}
+
+// LocalityType Returns gcp_jwt, the locality-type of an identity token issued by Google, as the instance's own is.
+func (w *WorkloadLocation) LocalityType() string {
+	return "gcp_jwt"
+}
+
+// NewWorkloadLocator Returns the Locator of a NewWorkloadLocation.
+// A token file is read every time it's needed, so rotated tokens are picked up,
+// but a token command's token is reused until it expires rather than running the command for every request.
+func NewWorkloadLocator(location, tokenFile, tokenCommand string) (Locator, error) {
+	w, err := NewWorkloadLocation(location, tokenFile, tokenCommand)
+	if err != nil {
+		return nil, err
+	}
+	if w.TokenFile != "" {
+		return w, nil
+	}
+	return NewCachedLocator(w), nil
+}
+
+func runTokenCommand(command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), TokenCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	token, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "workload identity token command failed: %s: %s", command, strings.TrimSpace(stderr.String()))
+	}
+	return token, nil
+}
@@ -0,0 +1,217 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Author: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// Service The service given to files found in a local directory.
+const Service = "local"
+
+// Local Stands in for the SDL API by serving accessions from a directory of local files or a manifest.
+// Files in a manifest may have file:// links to local files or plain http(s):// links, such as objects in a public bucket.
+type Local struct {
+	// Acc Limits the accessions served to these when not empty.
+	Acc []string
+	// FileType Limits the files served to these types when not nil.
+	FileType map[string]bool
+
+	accs  map[string]*fuseralib.Accession
+	order []string
+}
+
+// New Returns a Local serving the directory or manifest at path.
+func New(path string) (*Local, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't open local data at: %s", path)
+	}
+	if fi.IsDir() {
+		return FromDir(path)
+	}
+	return FromManifest(path)
+}
+
+// FromDir Returns a Local where each subdirectory of dir is an accession and each regular file within is one of its files.
+func FromDir(dir string) (*Local, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't resolve local data directory: %s", dir)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't open local data at: %s", dir)
+	}
+	accs := make([]*fuseralib.Accession, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		acc, err := accessionFromDir(e.Name(), filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		accs = append(accs, acc)
+	}
+	if len(accs) == 0 {
+		return nil, errors.Errorf("local data directory had no accession directories: %s", dir)
+	}
+	return newLocal(accs), nil
+}
+
+// FromManifest Returns a Local serving the accessions in the manifest at path.
+func FromManifest(path string) (*Local, error) {
+	// Local data is never renewed by the SDL API, so it doesn't matter which version resolved it.
+	m, err := fuseralib.LoadManifest(path, "")
+	if err != nil {
+		return nil, err
+	}
+	return newLocal(m.List()), nil
+}
+
+func newLocal(accs []*fuseralib.Accession) *Local {
+	l := &Local{accs: make(map[string]*fuseralib.Accession, len(accs))}
+	for _, a := range accs {
+		l.accs[a.ID] = a
+		l.order = append(l.order, a.ID)
+	}
+	sort.Strings(l.order)
+	return l
+}
+
+func accessionFromDir(id, dir string) (*fuseralib.Accession, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read accession directory: %s", dir)
+	}
+	acc := &fuseralib.Accession{ID: id, Files: make(map[string]fuseralib.File)}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		sum, err := fuseralib.Md5File(path)
+		if err != nil {
+			return nil, err
+		}
+		acc.Files[e.Name()] = fuseralib.File{
+			Name:         e.Name(),
+			Size:         uint64(e.Size()),
+			Type:         FileType(e.Name()),
+			ModifiedDate: e.ModTime(),
+			Md5Hash:      sum,
+			Link:         "file://" + path,
+			Service:      Service,
+			Region:       Service,
+		}
+	}
+	if len(acc.Files) == 0 {
+		acc.AppendError("local accession directory has no files\n")
+	}
+	return acc, nil
+}
+
+// FileType Returns the type of a file going by its extension, ignoring any compression extension.
+func FileType(name string) string {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".bz2")
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	if ext == "" {
+		return "file"
+	}
+	return strings.ToLower(ext)
+}
+
+// requested Returns the IDs of the accessions asked for, all of them if none were.
+func (l *Local) requested() []string {
+	if len(l.Acc) == 0 {
+		return l.order
+	}
+	return l.Acc
+}
+
+// lookup Returns a copy of the accession, limited to the file types asked for.
+func (l *Local) lookup(id string) *fuseralib.Accession {
+	a, ok := l.accs[id]
+	if !ok {
+		errAcc := &fuseralib.Accession{ID: id, Files: make(map[string]fuseralib.File)}
+		errAcc.AppendError("accession not found in local data\n")
+		return errAcc
+	}
+	acc := &fuseralib.Accession{ID: a.ID, Files: make(map[string]fuseralib.File, len(a.Files))}
+	if a.HasError() {
+		acc.AppendError(a.ErrorLog())
+	}
+	for name, f := range a.Files {
+		if l.FileType != nil && !l.FileType[f.Type] {
+			continue
+		}
+		acc.Files[name] = f
+	}
+	return acc
+}
+
+// Retrieve Returns the accession from local data.
+func (l *Local) Retrieve(accession string) (*fuseralib.Accession, error) {
+	return l.lookup(accession), nil
+}
+
+// RetrieveAll Returns every accession asked for from local data.
+func (l *Local) RetrieveAll() ([]*fuseralib.Accession, error) {
+	ids := l.requested()
+	accs := make([]*fuseralib.Accession, 0, len(ids))
+	for _, id := range ids {
+		accs = append(accs, l.lookup(id))
+	}
+	return accs, nil
+}
+
+// Sign Returns the accession from local data, its links never need signing.
+func (l *Local) Sign(accession string) (*fuseralib.Accession, error) {
+	return l.Retrieve(accession)
+}
+
+// SignAll Returns every accession asked for from local data.
+func (l *Local) SignAll() ([]*fuseralib.Accession, error) {
+	return l.RetrieveAll()
+}
+
+// ServesLocalFiles Returns true, since the file:// links of local data are meant to be read.
+func (l *Local) ServesLocalFiles() bool {
+	return true
+}
+
+// RetrieveAllInBatch Returns every accession asked for from local data, there's nothing to gain from batching.
+func (l *Local) RetrieveAllInBatch(batch int) ([]*fuseralib.Accession, error) {
+	return l.RetrieveAll()
+}
+
+// SignAllInBatch Returns every accession asked for from local data, there's nothing to gain from batching.
+func (l *Local) SignAllInBatch(batch int) ([]*fuseralib.Accession, error) {
+	return l.RetrieveAll()
+}
+
+// AddIdent Returns link as it is, local data is never Compute Environment Required.
+func (l *Local) AddIdent(link string) (string, error) {
+	return link, nil
+}
@@ -16,19 +16,31 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/gorilla/mux"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/mitre/fusera/local"
+	"github.com/mitre/fusera/mock/sdltest"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
 	debug bool
+
+	data          string
+	addr          string
+	publicURL     string
+	linkTTL       time.Duration
+	requireNgc    bool
+	requesterPays bool
+	sdlVersion    string
 )
 
 func init() {
@@ -37,6 +49,14 @@ func init() {
 		panic("INTERNAL ERROR: could not bind debug flag to debug environment variable")
 	}
 
+	rootCmd.Flags().StringVarP(&data, "data", "", "", "A path to a directory or manifest of the accessions to serve. In a directory, each subdirectory is an accession containing its files.")
+	rootCmd.Flags().StringVarP(&addr, "addr", "", ":8080", "The address to listen on.")
+	rootCmd.Flags().StringVarP(&publicURL, "url", "", "", "The URL clients reach this server at, used to make links. Defaults to http://localhost with the port from addr.")
+	rootCmd.Flags().DurationVarP(&linkTTL, "link-ttl", "", time.Hour, "How long signed links are good for.")
+	rootCmd.Flags().BoolVarP(&requireNgc, "require-ngc", "", false, "Deny every accession to requests without an ngc file.")
+	rootCmd.Flags().BoolVarP(&requesterPays, "requester-pays", "", false, "Mark every file as payRequired and refuse GETs without the x-amz-request-payer: requester header. They are served path style at /sdltest/<key>, as S3 serves a bucket, to clients given this server as their S3 endpoint, such as a fuseralib.Reader with S3Endpoint set.")
+	rootCmd.Flags().StringVarP(&sdlVersion, "sdl-version", "", "2", "The version of the SDL API to claim to be.")
+
 	viper.AutomaticEnv()
 }
 
@@ -48,55 +68,76 @@ var rootCmd = &cobra.Command{
 }
 
 func run(cmd *cobra.Command, args []string) error {
-	// Start up an http server and serve 5019 accessions named an+1 with n starting at 0
-	r := mux.NewRouter()
-	r.HandleFunc("/", HomeHandler)
-	http.Handle("/", r)
-	http.ListenAndServe(":8080", r)
-	return nil
-}
-
-type payload struct {
-	ID      string `json:"accession,omitempty"`
-	Status  int    `json:"status,omitempty"`
-	Message string `json:"message,omitempty"`
-	Files   []file `json:"files,omitempty"`
+	if data == "" {
+		return errors.New("no data provided: mocksdlapi needs a directory or manifest of accessions to serve")
+	}
+	if publicURL == "" {
+		port := addr[strings.LastIndex(addr, ":")+1:]
+		publicURL = "http://localhost:" + port
+	}
+	store := sdltest.NewStoreAt(publicURL)
+	store.LinkTTL = linkTTL
+	store.RequesterPays = requesterPays
+	server := sdltest.NewServerAt(store)
+	server.Version = sdlVersion
+	server.RequireNgc = requireNgc
+	if err := load(server, data); err != nil {
+		return err
+	}
+	fmt.Printf("Serving SDL API v%s at: %s/retrieve\n", sdlVersion, publicURL)
+	return http.ListenAndServe(addr, server)
 }
 
-type file struct {
-	Name           string    `json:"name,omitempty"`
-	Size           string    `json:"size,omitempty"`
-	Type           string    `json:"type,omitempty"`
-	ModifiedDate   time.Time `json:"modificationDate,omitempty"`
-	Md5Hash        string    `json:"md5,omitempty"`
-	Link           string    `json:"link,omitempty"`
-	ExpirationDate time.Time `json:"expirationDate,omitempty"`
-	Bucket         string    `json:"bucket,omitempty"`
-	Key            string    `json:"key,omitempty"`
-	Service        string    `json:"service,omitempty"`
+// load Adds the accessions found at path to server.
+func load(server *sdltest.Server, path string) error {
+	provider, err := local.New(path)
+	if err != nil {
+		return err
+	}
+	accs, err := provider.RetrieveAll()
+	if err != nil {
+		return err
+	}
+	for _, a := range accs {
+		files := make([]sdltest.File, 0, len(a.Files))
+		for _, f := range a.Files {
+			file, err := toFile(f)
+			if err != nil {
+				fmt.Printf("skipping %s/%s: %s\n", a.ID, f.Name, err.Error())
+				continue
+			}
+			files = append(files, file)
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+		if err := server.AddAccession(a.ID, files...); err != nil {
+			return errors.Wrapf(err, "couldn't serve accession: %s", a.ID)
+		}
+		if debug {
+			fmt.Printf("serving accession %s with %d file(s)\n", a.ID, len(files))
+		}
+	}
+	return nil
 }
 
-// HomeHandler returns whatever JSON I want.
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	response := make([]payload, 1, 1)
-	for i := range response {
-		response[i].ID = "a" + fmt.Sprintf("%d", i)
-		response[i].Status = 200
-		response[i].Files = make([]file, 1, 1)
-		for j := range response[i].Files {
-			response[i].Files[j].Name = "test.txt"
-			response[i].Files[j].Bucket = "matt-first-test-bucket"
-			response[i].Files[j].Key = "test.txt"
-			response[i].Files[j].Size = "51"
-			//response[i].Files[j].ExpirationDate = time.Now().Add(time.Hour)
-		}
+func toFile(f fuseralib.File) (sdltest.File, error) {
+	if !strings.HasPrefix(f.Link, "file://") {
+		return sdltest.File{}, errors.Errorf("only file:// links can be served, got: %s", f.Link)
+	}
+	file := sdltest.File{
+		Name:        f.Name,
+		Type:        f.Type,
+		Path:        strings.TrimPrefix(f.Link, "file://"),
+		Size:        f.Size,
+		Md5Hash:     f.Md5Hash,
+		CeRequired:  f.CeRequired,
+		PayRequired: f.PayRequired || requesterPays,
 	}
-	js, _ := json.Marshal(&response)
-	if err := json.NewEncoder(w).Encode(&response); err != nil {
-		panic("couldn't encode json")
+	// Files found in a directory take on the locality asked for.
+	if f.Service != local.Service {
+		file.Service = f.Service
+		file.Region = f.Region
 	}
-	fmt.Println(string(js))
+	return file, nil
 }
 
 // Execute runs the root command of mocksdlapi.
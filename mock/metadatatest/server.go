@@ -0,0 +1,291 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadatatest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitre/fusera/gps"
+)
+
+// The clouds a Server can impersonate.
+const (
+	AWS   = "aws"
+	GCP   = "gcp"
+	Azure = "azure"
+)
+
+// The path of each cloud's metadata service on a Server, which gps is pointed at by Use.
+const (
+	awsPrefix   = "/latest"
+	gcpPrefix   = "/computeMetadata/v1"
+	azurePrefix = "/metadata"
+)
+
+// Request What the Server was asked for, recorded for making assertions.
+type Request struct {
+	// Cloud Whose metadata service was asked: AWS, GCP, or Azure.
+	Cloud  string
+	Method string
+	// Path The path under the cloud's metadata URL, such as /dynamic/instance-identity/document.
+	Path   string
+	Header http.Header
+}
+
+type failure struct {
+	status int
+	body   string
+}
+
+// Server A fake instance metadata service that answers as the instance of one cloud would,
+// and as any other cloud's instance wouldn't, so gps detects the cloud it impersonates.
+type Server struct {
+	// Cloud The cloud the Server impersonates: AWS, GCP, or Azure.
+	Cloud string
+	// Region What the Server gives as the instance's region, or zone on GCP.
+	Region string
+	// Identity What the Server gives as the instance's identity: the pkcs7 signature on AWS,
+	// the identity token on GCP, and the attested data signature on Azure.
+	// Defaults to an unsigned JWT that expires an hour after it's asked for.
+	Identity string
+	// RequireIMDSv2 Refuses AWS requests without a session token, as hardened instances do.
+	RequireIMDSv2 bool
+	// DisableIMDSv2 Refuses to give out AWS session tokens, as old instances do.
+	DisableIMDSv2 bool
+	// Delay How long to wait before answering anything, to make requests time out.
+	Delay time.Duration
+
+	mu       sync.Mutex
+	failures map[string]failure
+	requests []Request
+	server   *httptest.Server
+}
+
+// NewServer Starts a Server impersonating an instance of cloud in region on a local port. Close it when done.
+func NewServer(cloud, region string) *Server {
+	s := &Server{
+		Cloud:    cloud,
+		Region:   region,
+		failures: make(map[string]failure),
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// NewAwsServer Starts a Server impersonating an AWS instance in region.
+func NewAwsServer(region string) *Server {
+	return NewServer(AWS, region)
+}
+
+// NewGcpServer Starts a Server impersonating a GCP instance in zone.
+func NewGcpServer(zone string) *Server {
+	return NewServer(GCP, zone)
+}
+
+// NewAzureServer Starts a Server impersonating an Azure VM in region.
+func NewAzureServer(region string) *Server {
+	return NewServer(Azure, region)
+}
+
+// Close Shuts down the Server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Use Points gps at the Server for every cloud, returning a function that points it back where it was.
+func (s *Server) Use() (restore func()) {
+	aws, gcp, azure := gps.AwsMetadataURL, gps.GcpMetadataURL, gps.AzureMetadataURL
+	gps.AwsMetadataURL = s.server.URL + awsPrefix
+	gps.GcpMetadataURL = s.server.URL + gcpPrefix
+	gps.AzureMetadataURL = s.server.URL + azurePrefix
+	return func() {
+		gps.AwsMetadataURL, gps.GcpMetadataURL, gps.AzureMetadataURL = aws, gcp, azure
+	}
+}
+
+// Env Returns the environment variables that point a fusera or sracp process at the Server.
+func (s *Server) Env() []string {
+	return []string{
+		"DBGAP_AWS_METADATA_URL=" + s.server.URL + awsPrefix,
+		"DBGAP_GCP_METADATA_URL=" + s.server.URL + gcpPrefix,
+		"DBGAP_AZURE_METADATA_URL=" + s.server.URL + azurePrefix,
+	}
+}
+
+// Fail Answers requests for path, such as /api/token or /instance/zone, with status and body from now on.
+func (s *Server) Fail(path string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = failure{status: status, body: body}
+}
+
+// Recover Stops failing requests for path.
+func (s *Server) Recover(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, path)
+}
+
+// Requests Returns every request made to the Server so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// ServeHTTP Answers as the metadata service of the cloud the Server impersonates.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Delay > 0 {
+		select {
+		case <-time.After(s.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	cloud, path := split(r.URL.Path)
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Cloud: cloud, Method: r.Method, Path: path, Header: r.Header})
+	f, failing := s.failures[path]
+	s.mu.Unlock()
+	if cloud != s.Cloud {
+		http.NotFound(w, r)
+		return
+	}
+	if failing {
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, f.body)
+		return
+	}
+	switch s.Cloud {
+	case AWS:
+		s.serveAws(w, r, path)
+	case GCP:
+		s.serveGcp(w, r, path)
+	case Azure:
+		s.serveAzure(w, r, path)
+	}
+}
+
+func split(path string) (cloud, rest string) {
+	switch {
+	case strings.HasPrefix(path, awsPrefix+"/"):
+		return AWS, strings.TrimPrefix(path, awsPrefix)
+	case strings.HasPrefix(path, gcpPrefix+"/"):
+		return GCP, strings.TrimPrefix(path, gcpPrefix)
+	case strings.HasPrefix(path, azurePrefix+"/"):
+		return Azure, strings.TrimPrefix(path, azurePrefix)
+	}
+	return "", path
+}
+
+// awsSessionToken The session token the Server gives out.
+const awsSessionToken = "metadatatest-session-token"
+
+func (s *Server) serveAws(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/api/token" {
+		if r.Method != "PUT" || s.DisableIMDSv2 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, awsSessionToken)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch token := r.Header.Get("X-aws-ec2-metadata-token"); {
+	case token == "" && s.RequireIMDSv2:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	case token != "" && token != awsSessionToken:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch path {
+	case "/dynamic/instance-identity/document":
+		json.NewEncoder(w).Encode(map[string]string{"region": s.Region, "instanceId": "i-metadatatest"})
+	case "/dynamic/instance-identity/pkcs7":
+		fmt.Fprint(w, s.identity())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveGcp(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "Missing Metadata-Flavor:Google header.", http.StatusForbidden)
+		return
+	}
+	switch path {
+	case "/instance/zone":
+		json.NewEncoder(w).Encode("projects/123456789/zones/" + s.Region)
+	case "/instance/service-accounts/default/identity":
+		if r.URL.Query().Get("audience") == "" {
+			http.Error(w, "non-empty audience parameter required", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, s.identity())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveAzure(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Header.Get("Metadata") != "true" {
+		http.Error(w, `{"error":"Bad request. Required metadata header not specified"}`, http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		http.Error(w, `{"error":"Bad request. api-version was not specified in the request"}`, http.StatusBadRequest)
+		return
+	}
+	switch path {
+	case "/instance/compute/location":
+		fmt.Fprint(w, s.Region)
+	case "/attested/document":
+		json.NewEncoder(w).Encode(map[string]string{"encoding": "pkcs7", "signature": s.identity()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// identity Returns Identity, or an unsigned JWT that expires in an hour if it's empty.
+func (s *Server) identity() string {
+	if s.Identity != "" {
+		return s.Identity
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	claims, _ := json.Marshal(map[string]interface{}{
+		"aud": "https://www.ncbi.nlm.nih.gov",
+		"iss": "metadatatest",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	return encode([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + encode(claims) + "."
+}
@@ -0,0 +1,354 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdltest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitre/fusera/sdl"
+)
+
+// File A file belonging to an accession served by the Server.
+type File struct {
+	Name string
+	Type string
+	// Data The contents of the file, put in the Store under Accession/Name.
+	Data []byte
+	// Path Serves the file from disk instead of Data.
+	// Size and Md5Hash should be given along with it.
+	Path    string
+	Size    uint64
+	Md5Hash string
+	// Service and Region default to the locality asked for when forced, otherwise s3 and us-east-1.
+	Service     string
+	Region      string
+	CeRequired  bool
+	PayRequired bool
+}
+
+// Request What the Server was asked for, recorded for making assertions.
+type Request struct {
+	Acc    []string
+	Fields map[string]string
+	Ngc    []byte
+}
+
+type accession struct {
+	id      string
+	status  int
+	message string
+	files   []File
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Server A fake SDL API whose links point into a Store.
+type Server struct {
+	// Version The version the Server claims to respond with. Version 1 responds with a bare list of accessions.
+	Version string
+	// Store Where the Server's links point.
+	Store *Store
+	// RequireNgc Denies every accession to requests without an ngc file.
+	RequireNgc bool
+
+	mu       sync.Mutex
+	accs     map[string]*accession
+	failures []apiError
+	requests []Request
+	server   *httptest.Server
+}
+
+// NewServer Starts a Server on a local port with a Store of its own. Close it when done.
+func NewServer() *Server {
+	s := NewServerAt(NewStore())
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// NewServerAt Returns a Server that isn't listening, with links pointing into store, for serving from a server of the caller's choosing.
+func NewServerAt(store *Store) *Server {
+	return &Server{
+		Version: "2",
+		Store:   store,
+		accs:    make(map[string]*accession),
+	}
+}
+
+// URL Returns the endpoint to give to sdl.SDL when the Server was started by NewServer.
+func (s *Server) URL() string {
+	if s.server == nil {
+		return ""
+	}
+	return s.server.URL + "/retrieve"
+}
+
+// Close Shuts down the Server and its Store if they were started by NewServer.
+func (s *Server) Close() {
+	if s.server != nil {
+		s.server.Close()
+		s.Store.Close()
+	}
+}
+
+// AddAccession Serves the accession with files, putting their data in the Store.
+func (s *Server) AddAccession(id string, files ...File) error {
+	for i := range files {
+		key := id + "/" + files[i].Name
+		if files[i].Path != "" {
+			if err := s.Store.PutFile(key, files[i].Path); err != nil {
+				return err
+			}
+			continue
+		}
+		sum := md5.Sum(files[i].Data)
+		files[i].Size = uint64(len(files[i].Data))
+		files[i].Md5Hash = hex.EncodeToString(sum[:])
+		s.Store.Put(key, files[i].Data)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accs[id] = &accession{id: id, status: http.StatusOK, files: files}
+	return nil
+}
+
+// SetStatus Makes the accession come back with status and message instead of its files.
+func (s *Server) SetStatus(id string, status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accs[id]
+	if !ok {
+		a = &accession{id: id}
+		s.accs[id] = a
+	}
+	a.status = status
+	a.message = message
+}
+
+// FailNext Queues a failure of the whole request, used up by the next request.
+func (s *Server) FailNext(status int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, apiError{Status: status, Message: message})
+}
+
+// Requests Returns every request the Server has received, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rr := make([]Request, len(s.requests))
+	copy(rr, s.requests)
+	return rr
+}
+
+// ServeHTTP Answers requests at /retrieve the way the SDL API does, and serves the Store's objects at /o/ and /<Bucket>/.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/o/") || strings.HasPrefix(r.URL.Path, "/"+Bucket+"/") {
+		s.Store.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "couldn't parse multipart form: "+err.Error())
+		return
+	}
+	req := parseRequest(r)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var failure *apiError
+	if len(s.failures) > 0 {
+		failure = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != nil {
+		writeError(w, failure.Status, failure.Message)
+		return
+	}
+	if len(req.Acc) == 0 {
+		writeError(w, http.StatusBadRequest, "no accessions were given")
+		return
+	}
+	var message interface{} = s.respond(req)
+	if s.Version == "1" {
+		message = toV1(message.(*sdl.VersionWrap))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		panic("INTERNAL ERROR: couldn't encode response from fake SDL API")
+	}
+}
+
+type v1Accession struct {
+	ID      string    `json:"accession,omitempty"`
+	Status  int       `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Files   []*v1File `json:"files,omitempty"`
+}
+
+type v1File struct {
+	Name         string    `json:"name,omitempty"`
+	Size         string    `json:"size,omitempty"`
+	Type         string    `json:"type,omitempty"`
+	ModifiedDate time.Time `json:"modificationDate,omitempty"`
+	Md5Hash      string    `json:"md5,omitempty"`
+	sdl.Location
+}
+
+// toV1 Returns message the way version 1 of the SDL API responded: a bare list of accessions,
+// with each file's location inline and its size as a string.
+func toV1(message *sdl.VersionWrap) []*v1Accession {
+	aa := make([]*v1Accession, 0, len(message.Result))
+	for _, a := range message.Result {
+		acc := &v1Accession{ID: a.ID, Status: a.Status, Message: a.Message}
+		for _, f := range a.Files {
+			file := &v1File{
+				Name:         f.Name,
+				Size:         strconv.FormatUint(f.Size, 10),
+				Type:         f.Type,
+				ModifiedDate: f.ModifiedDate,
+				Md5Hash:      f.Md5Hash,
+			}
+			if len(f.Locations) > 0 {
+				file.Location = f.Locations[0]
+			}
+			acc.Files = append(acc.Files, file)
+		}
+		aa = append(aa, acc)
+	}
+	return aa
+}
+
+func parseRequest(r *http.Request) Request {
+	req := Request{Fields: make(map[string]string)}
+	for k, v := range r.MultipartForm.Value {
+		if len(v) > 0 {
+			req.Fields[k] = v[0]
+		}
+	}
+	for _, a := range strings.Split(req.Fields["acc"], ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			req.Acc = append(req.Acc, a)
+		}
+	}
+	if fhs := r.MultipartForm.File["ngc"]; len(fhs) > 0 {
+		if f, err := fhs[0].Open(); err == nil {
+			req.Ngc, _ = ioutil.ReadAll(f)
+			f.Close()
+		}
+	}
+	return req
+}
+
+func (s *Server) respond(req Request) *sdl.VersionWrap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	types := splitList(req.Fields["filetype"])
+	metaOnly := req.Fields["meta-only"] == "yes"
+	service, region := "s3", "us-east-1"
+	if req.Fields["locality-type"] == "forced" {
+		if parts := strings.SplitN(req.Fields["locality"], ".", 2); len(parts) == 2 {
+			service, region = parts[0], parts[1]
+		}
+	}
+	message := &sdl.VersionWrap{Version: s.Version}
+	for _, id := range req.Acc {
+		a, ok := s.accs[id]
+		if !ok {
+			message.Result = append(message.Result, &sdl.Accession{ID: id, Status: http.StatusNotFound, Message: "No data at given locality"})
+			continue
+		}
+		if s.RequireNgc && len(req.Ngc) == 0 {
+			message.Result = append(message.Result, &sdl.Accession{ID: id, Status: http.StatusForbidden, Message: "Access denied - object requires an ngc file"})
+			continue
+		}
+		acc := &sdl.Accession{ID: id, Status: a.status, Message: a.message}
+		if a.status == http.StatusOK {
+			for _, f := range a.files {
+				if len(types) > 0 && !types[f.Type] {
+					continue
+				}
+				acc.Files = append(acc.Files, s.file(id, f, service, region, metaOnly))
+			}
+			sort.Slice(acc.Files, func(i, j int) bool { return acc.Files[i].Name < acc.Files[j].Name })
+		}
+		message.Result = append(message.Result, acc)
+	}
+	return message
+}
+
+// file LOCKS_REQUIRED(s.mu)
+func (s *Server) file(id string, f File, service, region string, metaOnly bool) *sdl.File {
+	if f.Service != "" {
+		service = f.Service
+	}
+	if f.Region != "" {
+		region = f.Region
+	}
+	key := id + "/" + f.Name
+	l := sdl.Location{
+		Service:     service,
+		Region:      region,
+		CeRequired:  f.CeRequired,
+		PayRequired: f.PayRequired,
+		Bucket:      Bucket,
+		Key:         key,
+	}
+	if !metaOnly {
+		l.Link, l.ExpirationDate = s.Store.Link(key)
+	}
+	return &sdl.File{
+		Name:         f.Name,
+		Size:         f.Size,
+		Type:         f.Type,
+		ModifiedDate: time.Now().UTC().Truncate(time.Second),
+		Md5Hash:      f.Md5Hash,
+		Locations:    []sdl.Location{l},
+	}
+}
+
+func splitList(list string) map[string]bool {
+	m := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			m[item] = true
+		}
+	}
+	return m
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&apiError{Status: status, Message: message})
+}
@@ -0,0 +1,293 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sdltest provides an in-process fake of the SDL API and of the
+// object store its signed links point to, for testing sdl, fuseralib and
+// sracp without NCBI or cloud credentials.
+package sdltest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failure A failure the Store can be told to respond with instead of an object.
+type Failure int
+
+const (
+	// Forbidden Responds with 403 Forbidden, as a bucket does when credentials are wrong.
+	Forbidden Failure = iota + 1
+	// ServerError Responds with 500 Internal Server Error.
+	ServerError
+	// Truncated Promises the whole range asked for, but only sends half of it before hanging up.
+	Truncated
+	// Expired Responds as though the link had expired, no matter its expiration date.
+	Expired
+)
+
+// Store A fake object store serving objects through signed, expiring links with support for ranged GETs.
+type Store struct {
+	// BaseURL The URL links are made relative to.
+	BaseURL string
+	// LinkTTL How long links made by Link are good for. Negative values make links that have already expired.
+	LinkTTL time.Duration
+	// RequesterPays Refuses GETs that don't acknowledge the requester will be charged,
+	// the way a requester pays bucket does.
+	RequesterPays bool
+
+	mu       sync.Mutex
+	objects  map[string]*object
+	failures map[string][]Failure
+	gets     map[string]int
+	secret   []byte
+	server   *httptest.Server
+}
+
+type object struct {
+	data    []byte
+	path    string
+	modTime time.Time
+}
+
+// open Returns the contents of the object, reading them from disk if that's where they are.
+func (o *object) open() (io.ReadSeeker, func(), error) {
+	if o.path == "" {
+		return bytes.NewReader(o.data), func() {}, nil
+	}
+	f, err := os.Open(o.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// NewStore Starts a Store on a local port. Close it when done.
+func NewStore() *Store {
+	s := NewStoreAt("")
+	s.server = httptest.NewServer(s)
+	s.BaseURL = s.server.URL
+	return s
+}
+
+// NewStoreAt Returns a Store that isn't listening, for serving from a server of the caller's choosing at baseURL.
+func NewStoreAt(baseURL string) *Store {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("INTERNAL ERROR: couldn't generate secret for signing links")
+	}
+	return &Store{
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		LinkTTL:  time.Hour,
+		objects:  make(map[string]*object),
+		failures: make(map[string][]Failure),
+		gets:     make(map[string]int),
+		secret:   secret,
+	}
+}
+
+// Close Shuts down the Store if it was started by NewStore.
+func (s *Store) Close() {
+	if s.server != nil {
+		s.server.Close()
+	}
+}
+
+// Put Stores data under key, replacing whatever was there.
+func (s *Store) Put(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &object{data: data, modTime: time.Now()}
+}
+
+// PutFile Serves the file at path under key, reading it from disk on every GET.
+func (s *Store) PutFile(key, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &object{path: path, modTime: fi.ModTime()}
+	return nil
+}
+
+// Fail Queues failures for key, each used up by one GET of key in the order given.
+func (s *Store) Fail(key string, ff ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[key] = append(s.failures[key], ff...)
+}
+
+// Gets Returns how many GETs have been made for key, failed or not.
+func (s *Store) Gets(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets[key]
+}
+
+// Link Returns a signed link to key and when it expires.
+func (s *Store) Link(key string) (string, time.Time) {
+	expiration := time.Now().Add(s.LinkTTL).UTC().Truncate(time.Second)
+	expires := strconv.FormatInt(expiration.Unix(), 10)
+	return fmt.Sprintf("%s/o/%s?expires=%s&sig=%s", s.BaseURL, url.PathEscape(key), expires, s.sign(key, expires)), expiration
+}
+
+func (s *Store) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nextFailure LOCKS_REQUIRED(s.mu)
+func (s *Store) nextFailure(key string) Failure {
+	ff := s.failures[key]
+	if len(ff) == 0 {
+		return 0
+	}
+	s.failures[key] = ff[1:]
+	return ff[0]
+}
+
+// Bucket The bucket the Store's objects are said to be in, and the path it serves them at as S3 does, path style.
+const Bucket = "sdltest"
+
+// ServeHTTP Serves objects at /o/<key> when the link's signature checks out and hasn't expired,
+// and at /<Bucket>/<key> the way S3 serves them to requests made with credentials, which aren't checked.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/"+Bucket+"/") {
+		key := strings.TrimPrefix(r.URL.Path, "/"+Bucket+"/")
+		s.mu.Lock()
+		s.gets[key]++
+		failure := s.nextFailure(key)
+		s.mu.Unlock()
+		if failure == Expired {
+			http.Error(w, "request has expired", http.StatusForbidden)
+			return
+		}
+		s.serve(w, r, key, failure)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/o/") {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/o/")
+	q := r.URL.Query()
+	expires := q.Get("expires")
+
+	s.mu.Lock()
+	s.gets[key]++
+	failure := s.nextFailure(key)
+	valid := hmac.Equal([]byte(q.Get("sig")), []byte(s.sign(key, expires)))
+	s.mu.Unlock()
+
+	if !valid {
+		http.Error(w, "signature does not match", http.StatusForbidden)
+		return
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp || failure == Expired {
+		http.Error(w, "request has expired", http.StatusForbidden)
+		return
+	}
+	s.serve(w, r, key, failure)
+}
+
+// serve Responds with the object at key, or with failure.
+func (s *Store) serve(w http.ResponseWriter, r *http.Request, key string, failure Failure) {
+	if s.RequesterPays && !strings.EqualFold(r.Header.Get("x-amz-request-payer"), "requester") {
+		http.Error(w, "requester pays bucket, x-amz-request-payer: requester header is required", http.StatusForbidden)
+		return
+	}
+	switch failure {
+	case Forbidden:
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	case ServerError:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	content, done, err := obj.open()
+	if err != nil {
+		http.Error(w, "couldn't open object", http.StatusInternalServerError)
+		return
+	}
+	defer done()
+	if failure == Truncated {
+		data, err := ioutil.ReadAll(content)
+		if err != nil {
+			http.Error(w, "couldn't read object", http.StatusInternalServerError)
+			return
+		}
+		serveTruncated(w, r, data)
+		return
+	}
+	http.ServeContent(w, r, key, obj.modTime, content)
+}
+
+// serveTruncated Advertises the length of the range asked for, then sends only half of it.
+func serveTruncated(w http.ResponseWriter, r *http.Request, data []byte) {
+	start, end := int64(0), int64(len(data))
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") {
+		parts := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+		if n, err := strconv.ParseInt(parts[0], 10, 64); err == nil && n < end {
+			start = n
+			status = http.StatusPartialContent
+		}
+		if len(parts) == 2 {
+			if n, err := strconv.ParseInt(parts[1], 10, 64); err == nil && n+1 < end {
+				end = n + 1
+			}
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(data)))
+	}
+	w.WriteHeader(status)
+	w.Write(data[start : start+(end-start)/2])
+	// Hang up without sending the rest.
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Author: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Adapter Translates the body of a response from one version of the SDL API into the VersionWrap that the rest of this package validates and transfigures.
+type Adapter interface {
+	Decode(body []byte, param *Param) (*VersionWrap, error)
+}
+
+var adapters = map[string]Adapter{
+	"1": &v1Adapter{},
+	"2": &v2Adapter{},
+	"3": &v3Adapter{},
+}
+
+// RegisterAdapter Makes an Adapter available for the given version of the SDL API, replacing any Adapter already registered for it.
+func RegisterAdapter(version string, a Adapter) {
+	adapters[version] = a
+}
+
+// SupportedVersions Returns the versions of the SDL API that have an Adapter, in order.
+func SupportedVersions() []string {
+	vv := make([]string, 0, len(adapters))
+	for v := range adapters {
+		vv = append(vv, v)
+	}
+	sort.Strings(vv)
+	return vv
+}
+
+// ValidateVersion Returns an error if there is no Adapter for version.
+func ValidateVersion(version string) error {
+	if _, ok := adapters[version]; !ok {
+		return errors.Errorf("unsupported SDL API version: %s, supported versions are: %s", version, strings.Join(SupportedVersions(), ", "))
+	}
+	return nil
+}
+
+// Endpoint Returns the default endpoint for the given version of the SDL API.
+func Endpoint(version string) string {
+	return fmt.Sprintf("https://www.ncbi.nlm.nih.gov/Traces/sdl/%s/retrieve", version)
+}
+
+// decode Negotiates which Adapter to use for body by looking at the version the SDL API says it responded with.
+// The version responded with must be the version that was asked for, otherwise a change on the API's side could silently break parsing.
+func decode(body []byte, version string, param *Param) (*VersionWrap, error) {
+	got, err := detectVersion(body)
+	if err != nil {
+		return nil, err
+	}
+	if got != version {
+		return nil, errors.Errorf("expected SDL API version: %s, got version: %s", version, got)
+	}
+	a, ok := adapters[got]
+	if !ok {
+		return nil, errors.Errorf("unsupported SDL API version: %s", got)
+	}
+	return a.Decode(body, param)
+}
+
+// detectVersion Version 1 of the SDL API responds with a bare list of accessions, every version after wraps its result with the version it is.
+func detectVersion(body []byte) (string, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return "", errors.New("SDL API returned an empty body")
+	}
+	if trimmed[0] == '[' {
+		return "1", nil
+	}
+	var wrap struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(trimmed, &wrap); err != nil {
+		return "", errors.Wrap(err, "failed to decode version from SDL API response")
+	}
+	if wrap.Version == "" {
+		return "", errors.New("SDL API response did not include a version")
+	}
+	// Some deployments respond with "2.0" rather than "2".
+	return strings.TrimSuffix(wrap.Version, ".0"), nil
+}
+
+type v2Adapter struct{}
+
+// Decode Version 2 is the representation this package was built around, so there's nothing to translate.
+func (v *v2Adapter) Decode(body []byte, param *Param) (*VersionWrap, error) {
+	message := &VersionWrap{}
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response from SDL API v2")
+	}
+	message.Version = "2"
+	return message, nil
+}
+
+type v1Adapter struct{}
+
+type v1Accession struct {
+	ID      string    `json:"accession,omitempty"`
+	Status  int       `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Files   []*v1File `json:"files,omitempty"`
+}
+
+type v1File struct {
+	Name           string    `json:"name,omitempty"`
+	Size           flexSize  `json:"size,omitempty"`
+	Type           string    `json:"type,omitempty"`
+	ModifiedDate   time.Time `json:"modificationDate,omitempty"`
+	Md5Hash        string    `json:"md5,omitempty"`
+	Link           string    `json:"link,omitempty"`
+	ExpirationDate time.Time `json:"expirationDate,omitempty"`
+	Service        string    `json:"service,omitempty"`
+	Region         string    `json:"region,omitempty"`
+	Bucket         string    `json:"bucket,omitempty"`
+	Key            string    `json:"key,omitempty"`
+	CeRequired     bool      `json:"ceRequired,omitempty"`
+	PayRequired    bool      `json:"payRequired,omitempty"`
+}
+
+// Decode Version 1 has no wrapper and keeps the location of a file inline with the rest of the file's information.
+func (v *v1Adapter) Decode(body []byte, param *Param) (*VersionWrap, error) {
+	var aa []*v1Accession
+	if err := json.Unmarshal(body, &aa); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response from SDL API v1")
+	}
+	message := &VersionWrap{Version: "1", Result: make([]*Accession, 0, len(aa))}
+	for _, a := range aa {
+		acc := &Accession{
+			ID:      a.ID,
+			Status:  a.Status,
+			Message: a.Message,
+			Files:   make([]*File, 0, len(a.Files)),
+		}
+		for _, f := range a.Files {
+			acc.Files = append(acc.Files, &File{
+				Name:         f.Name,
+				Size:         uint64(f.Size),
+				Type:         f.Type,
+				ModifiedDate: f.ModifiedDate,
+				Md5Hash:      f.Md5Hash,
+				Locations: []Location{{
+					Link:           f.Link,
+					Service:        f.Service,
+					Region:         f.Region,
+					ExpirationDate: f.ExpirationDate,
+					CeRequired:     f.CeRequired,
+					PayRequired:    f.PayRequired,
+					Bucket:         f.Bucket,
+					Key:            f.Key,
+				}},
+			})
+		}
+		message.Result = append(message.Result, acc)
+	}
+	return message, nil
+}
+
+type v3Adapter struct{}
+
+// Decode Version 3 can offer more than one location for a file, so choose the one best suited to where we are.
+func (v *v3Adapter) Decode(body []byte, param *Param) (*VersionWrap, error) {
+	message := &VersionWrap{}
+	if err := json.Unmarshal(body, message); err != nil {
+		return nil, errors.Wrap(err, "failed to decode response from SDL API v3")
+	}
+	message.Version = "3"
+	for _, a := range message.Result {
+		for _, f := range a.Files {
+			if len(f.Locations) > 1 {
+				f.Locations = []Location{preferredLocation(f.Locations, param)}
+			}
+		}
+	}
+	return message, nil
+}
+
+// preferredLocation Returns the location on the same cloud as param's locator, favoring the same region too.
+// If no location is on the same cloud, the first location is returned.
+func preferredLocation(ll []Location, param *Param) Location {
+	if param == nil || param.Location == nil {
+		return ll[0]
+	}
+	cloud := param.Location.SdlCloudName()
+	var sameCloud []Location
+	for _, l := range ll {
+		if l.Service == cloud {
+			sameCloud = append(sameCloud, l)
+		}
+	}
+	if len(sameCloud) == 0 {
+		return ll[0]
+	}
+	region, err := param.Location.Region()
+	if err == nil {
+		for _, l := range sameCloud {
+			if l.Region == region {
+				return l
+			}
+		}
+	}
+	return sameCloud[0]
+}
+
+// flexSize Some versions of the SDL API represent sizes as strings, others as numbers.
+type flexSize uint64
+
+func (s *flexSize) UnmarshalJSON(data []byte) error {
+	raw := strings.Trim(string(data), "\"")
+	if raw == "" || raw == "null" {
+		*s = 0
+		return nil
+	}
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't parse file size: %s", raw)
+	}
+	*s = flexSize(n)
+	return nil
+}
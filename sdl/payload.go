@@ -20,11 +20,9 @@ type VersionWrap struct {
 // 1. Expected Version.
 // 2. Result isn't empty.
 func (v *VersionWrap) Validate() error {
-	// TODO: Have users set what version they want to use instead of endpoint.
-	// Then SdlVersion can be overwritten and this check can actually be valid.
-	// if info.SdlVersion != v.Version {
-	// 	return errors.Errorf("Expected version: %s, got version: %s", info.SdlVersion, v.Version)
-	// }
+	if info.SdlVersion != v.Version {
+		return errors.Errorf("Expected version: %s, got version: %s", info.SdlVersion, v.Version)
+	}
 	if len(v.Result) == 0 {
 		return errors.Errorf("SDL API v%s returned an empty response", info.SdlVersion)
 	}
@@ -45,7 +43,8 @@ type Accession struct {
 // 3. Files shouldn't be empty.
 // 4. It's not a duplicate accession (we should only get one of each accession).
 // 5. All Files are valid.
-func (a *Accession) Validate(isDup map[string]bool) error {
+// A metaOnly response doesn't sign links, so its files needn't have one.
+func (a *Accession) Validate(isDup map[string]bool, metaOnly bool) error {
 	if !info.LookUpAccession(a.ID) {
 		return errors.Errorf("SDL API v%s returned accession that wasn't requested: %s", info.SdlVersion, a.ID)
 	}
@@ -61,7 +60,7 @@ func (a *Accession) Validate(isDup map[string]bool) error {
 	isDup[a.ID] = true
 
 	for i := range a.Files {
-		err := a.Files[i].Validate()
+		err := a.Files[i].Validate(metaOnly)
 		if err != nil {
 			return err
 		}
@@ -100,7 +99,7 @@ type File struct {
 // 1. Files need a name.
 // 2. Files need a type.
 // 3. Files should have one location.
-func (f *File) Validate() error {
+func (f *File) Validate(metaOnly bool) error {
 	if f.Name == "" {
 		return errors.Errorf("SDL API v%s returned a file without a name", info.SdlVersion)
 	}
@@ -113,7 +112,7 @@ func (f *File) Validate() error {
 	if len(f.Locations) == 0 {
 		return errors.Errorf("SDL API v%s returned no locations for file: %s", info.SdlVersion, f.Name)
 	}
-	err := f.Locations[0].Validate()
+	err := f.Locations[0].Validate(metaOnly)
 	if err != nil {
 		return err
 	}
@@ -156,12 +155,12 @@ type Location struct {
 }
 
 // Validate Location
-// 1. Link shouldn't be empty.
+// 1. Link shouldn't be empty, unless only metadata was asked for.
 // 2. Service shouldn't be empty.
 // 3. Region shouldn't be empty.
 // 4. If PayRequired is true, there must be a Bucket and Key.
-func (l *Location) Validate() error {
-	if l.Link == "" {
+func (l *Location) Validate(metaOnly bool) error {
+	if l.Link == "" && !metaOnly {
 		return errors.Errorf("SDL API v%s returned a file without a link", info.SdlVersion)
 	}
 	if l.Service == "" {
@@ -31,10 +31,6 @@ import (
 	"github.com/pkg/errors"
 )
 
-var (
-	defaultEndpoint = fmt.Sprintf("https://www.ncbi.nlm.nih.gov/Traces/sdl/%s/retrieve", info.SdlVersion)
-)
-
 // SDL SDL is the main object to use when wanting to interact with the SDL API.
 type SDL struct {
 	URL   string
@@ -42,22 +38,36 @@ type SDL struct {
 }
 
 // NewSDL Creates a new SDL with default values already set.
+// The URL defaults to the endpoint of the SDL API version in info.SdlVersion.
 func NewSDL() *SDL {
 	return &SDL{
-		URL:   defaultEndpoint,
+		URL:   Endpoint(info.SdlVersion),
 		Param: &Param{},
 	}
 }
 
 // SignAllInBatch The function to call to get information on all the accessions, but in batches to avoid overloading the SDL API.
 func (s *SDL) SignAllInBatch(batch int) ([]*fuseralib.Accession, error) {
+	return s.inBatches(batch, signListed), nil
+}
+
+// RetrieveAllInBatch The function to call to get the metadata of all the accessions, but in batches to avoid overloading the SDL API.
+func (s *SDL) RetrieveAllInBatch(batch int) ([]*fuseralib.Accession, error) {
+	return s.inBatches(batch, retrieveListed), nil
+}
+
+// inBatches Asks for batch accessions at a time with request, returning the accessions of every batch that succeeded.
+// Batches that fail are printed rather than stopping the rest.
+func (s *SDL) inBatches(batch int, request func(url string, aa []string, param *Param) ([]*fuseralib.Accession, error)) []*fuseralib.Accession {
 	accessions := []*fuseralib.Accession{}
 	var rootErr []byte
-	// loop until all accessions are asked for
-	dot := batch
-	i := 0
-	for dot < len(s.Param.Acc) {
-		aa, err := signListed(s.URL, s.Param.Acc[i:dot], s.Param)
+	// loop until all accessions are asked for, once even if there are none since they might be in the token
+	for i := 0; ; {
+		dot := i + batch
+		if batch < 1 || dot > len(s.Param.Acc) {
+			dot = len(s.Param.Acc)
+		}
+		aa, err := request(s.URL, s.Param.Acc[i:dot], s.Param)
 		if err != nil {
 			rootErr = append(rootErr, []byte(fmt.Sprintln(err.Error()))...)
 			rootErr = append(rootErr, []byte("List of accessions that failed in this batch:\n")...)
@@ -68,22 +78,12 @@ func (s *SDL) SignAllInBatch(batch int) ([]*fuseralib.Accession, error) {
 		} else {
 			accessions = append(accessions, aa...)
 		}
-		i = dot
-		dot += batch
-	}
-	aa, err := signListed(s.URL, s.Param.Acc[i:], s.Param)
-	if err != nil {
-		rootErr = append(rootErr, []byte(fmt.Sprintln(err.Error()))...)
-		rootErr = append(rootErr, []byte("List of accessions that failed in this batch:\n")...)
-		rootErr = append(rootErr, []byte(fmt.Sprintln(s.Param.Acc[i:]))...)
-		if !flags.Silent {
-			fmt.Println(string(rootErr))
+		if dot >= len(s.Param.Acc) {
+			break
 		}
-	} else {
-		accessions = append(accessions, aa...)
+		i = dot
 	}
-
-	return accessions, nil
+	return accessions
 }
 
 func signListed(url string, aa []string, param *Param) ([]*fuseralib.Accession, error) {
@@ -101,7 +101,7 @@ func signListed(url string, aa []string, param *Param) ([]*fuseralib.Accession,
 		return nil, errors.New("could not close multipart.Writer")
 	}
 
-	return makeRequest(url, body, writer)
+	return makeRequest(url, body, writer, param, false)
 }
 
 // Sign The function to call to sign a single accession.
@@ -119,7 +119,7 @@ func (s *SDL) Sign(accession string) (*fuseralib.Accession, error) {
 	if err := writer.Close(); err != nil {
 		return nil, errors.New("could not close multipart.Writer")
 	}
-	accs, err := makeRequest(s.URL, body, writer)
+	accs, err := makeRequest(s.URL, body, writer, s.Param, false)
 	if err != nil {
 		return nil, err
 	}
@@ -154,10 +154,10 @@ func (s *SDL) SignAll() ([]*fuseralib.Accession, error) {
 		return nil, errors.New("could not close multipart.Writer")
 	}
 
-	return makeRequest(s.URL, body, writer)
+	return makeRequest(s.URL, body, writer, s.Param, false)
 }
 
-func makeRequest(url string, body *bytes.Buffer, writer *multipart.Writer) ([]*fuseralib.Accession, error) {
+func makeRequest(url string, body *bytes.Buffer, writer *multipart.Writer, param *Param, metaOnly bool) ([]*fuseralib.Accession, error) {
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, errors.New("can't create request to SDL API")
@@ -194,16 +194,19 @@ func makeRequest(url string, body *bytes.Buffer, writer *multipart.Writer) ([]*f
 		}
 		return nil, errors.Errorf("SDL API returned error: %d: %s", apiErr.Status, apiErr.Message)
 	}
-	message := VersionWrap{}
-	err = json.NewDecoder(resp.Body).Decode(&message)
+	payload, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode response from Name Resolver API")
+		return nil, errors.Wrap(err, "failed to read response from SDL API")
+	}
+	message, err := decode(payload, info.SdlVersion, param)
+	if err != nil {
+		return nil, err
 	}
 
-	return validate(message)
+	return validate(*message, metaOnly)
 }
 
-func validate(message VersionWrap) ([]*fuseralib.Accession, error) {
+func validate(message VersionWrap, metaOnly bool) ([]*fuseralib.Accession, error) {
 	err := message.Validate()
 	if err != nil {
 		return nil, err
@@ -211,7 +214,7 @@ func validate(message VersionWrap) ([]*fuseralib.Accession, error) {
 	dup := map[string]bool{}
 	list := make([]*fuseralib.Accession, 0, len(message.Result))
 	for i, a := range message.Result {
-		err := message.Result[i].Validate(dup)
+		err := message.Result[i].Validate(dup, metaOnly)
 		if err != nil {
 			if !flags.Silent {
 				fmt.Println(err.Error())
@@ -245,7 +248,7 @@ func (s *SDL) Retrieve(accession string) (*fuseralib.Accession, error) {
 	if err := writer.Close(); err != nil {
 		return nil, errors.New("could not close multipart.Writer")
 	}
-	accs, err := makeRequest(s.URL, body, writer)
+	accs, err := makeRequest(s.URL, body, writer, s.Param, true)
 	if err != nil {
 		return nil, err
 	}
@@ -257,13 +260,17 @@ func (s *SDL) Retrieve(accession string) (*fuseralib.Accession, error) {
 
 // RetrieveAll The function to call to get information on all the accessions.
 func (s *SDL) RetrieveAll() ([]*fuseralib.Accession, error) {
+	return retrieveListed(s.URL, s.Param.Acc, s.Param)
+}
+
+func retrieveListed(url string, aa []string, param *Param) ([]*fuseralib.Accession, error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
-	writer, err := s.Param.AddGlobals(writer)
+	writer, err := param.AddGlobals(writer)
 	if err != nil {
 		return nil, err
 	}
-	err = addAccessions(writer, s.Param.Acc)
+	err = addAccessions(writer, aa)
 	if err != nil {
 		return nil, err
 	}
@@ -275,5 +282,5 @@ func (s *SDL) RetrieveAll() ([]*fuseralib.Accession, error) {
 		return nil, errors.New("could not close multipart.Writer")
 	}
 
-	return makeRequest(s.URL, body, writer)
+	return makeRequest(url, body, writer, param, true)
 }
@@ -0,0 +1,181 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Author: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdl_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/gps"
+	"github.com/mitre/fusera/info"
+	"github.com/mitre/fusera/mock/sdltest"
+	"github.com/mitre/fusera/sdl"
+)
+
+// newSDL Returns an SDL asking server for accs from s3.us-east-1, and a func restoring the version asked for.
+func newSDL(t *testing.T, server *sdltest.Server, version string, accs ...string) (*sdl.SDL, func()) {
+	t.Helper()
+	location, err := gps.NewManualLocation("s3.us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, silent := info.SdlVersion, flags.Silent
+	info.SdlVersion = version
+	flags.Silent = true
+	info.LoadAccessionMap(accs)
+	api := sdl.NewSDL()
+	api.URL = server.URL()
+	api.Param = sdl.NewParam(accs, location, nil, "", nil)
+	return api, func() {
+		info.SdlVersion, flags.Silent = previous, silent
+	}
+}
+
+func TestDecodesEveryVersion(t *testing.T) {
+	tests := []struct {
+		name, responds, asks string
+	}{
+		{"v1 bare list", "1", "1"},
+		{"v2", "2", "2"},
+		{"v2 with minor version", "2.0", "2"},
+		{"v3", "3", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := sdltest.NewServer()
+			defer server.Close()
+			server.Version = tt.responds
+			if err := server.AddAccession("SRR1", sdltest.File{Name: "a.cram", Type: "cram", Data: []byte("cram data")}); err != nil {
+				t.Fatal(err)
+			}
+			api, restore := newSDL(t, server, tt.asks, "SRR1")
+			defer restore()
+
+			acc, err := api.Sign("SRR1")
+			if err != nil {
+				t.Fatalf("couldn't sign SRR1: %v", err)
+			}
+			if acc.HasError() {
+				t.Fatalf("SRR1 has errors: %s", acc.ErrorLog())
+			}
+			f, ok := acc.Files["a.cram"]
+			if !ok {
+				t.Fatalf("a.cram missing from %v", acc.Files)
+			}
+			if f.Size != uint64(len("cram data")) {
+				t.Errorf("size = %d, want %d", f.Size, len("cram data"))
+			}
+			if f.Link == "" || f.ExpirationDate.IsZero() {
+				t.Errorf("link = %q expiring %v, want a signed link", f.Link, f.ExpirationDate)
+			}
+			if f.Service != "s3" || f.Region != "us-east-1" || f.Bucket != sdltest.Bucket || f.Key != "SRR1/a.cram" {
+				t.Errorf("location = %s.%s %s/%s, want s3.us-east-1 %s/SRR1/a.cram", f.Service, f.Region, f.Bucket, f.Key, sdltest.Bucket)
+			}
+		})
+	}
+}
+
+func TestRefusesVersionNotAskedFor(t *testing.T) {
+	server := sdltest.NewServer()
+	defer server.Close()
+	server.Version = "3"
+	if err := server.AddAccession("SRR1", sdltest.File{Name: "a.cram", Type: "cram", Data: []byte("cram data")}); err != nil {
+		t.Fatal(err)
+	}
+	api, restore := newSDL(t, server, "2", "SRR1")
+	defer restore()
+
+	_, err := api.Sign("SRR1")
+	if err == nil || !strings.Contains(err.Error(), "expected SDL API version: 2, got version: 3") {
+		t.Fatalf("err = %v, want a version mismatch", err)
+	}
+}
+
+func TestServerErrorFailsOnlyItsBatch(t *testing.T) {
+	server := sdltest.NewServer()
+	defer server.Close()
+	for _, acc := range []string{"SRR1", "SRR2", "SRR3"} {
+		if err := server.AddAccession(acc, sdltest.File{Name: "a.cram", Type: "cram", Data: []byte(acc)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.FailNext(http.StatusInternalServerError, "internal error")
+	api, restore := newSDL(t, server, "2", "SRR1", "SRR2", "SRR3")
+	defer restore()
+
+	accs, err := api.SignAllInBatch(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range accs {
+		got = append(got, a.ID)
+	}
+	if !reflect.DeepEqual(got, []string{"SRR3"}) {
+		t.Errorf("accessions = %v, want only the batch after the failed one: [SRR3]", got)
+	}
+	var asked [][]string
+	for _, r := range server.Requests() {
+		asked = append(asked, r.Acc)
+	}
+	if want := [][]string{{"SRR1", "SRR2"}, {"SRR3"}}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("batches asked for = %v, want %v", asked, want)
+	}
+}
+
+func TestRetrieveAsksForMetadataOnly(t *testing.T) {
+	server := sdltest.NewServer()
+	defer server.Close()
+	if err := server.AddAccession("SRR1", sdltest.File{Name: "a.cram", Type: "cram", Data: []byte("cram data")}); err != nil {
+		t.Fatal(err)
+	}
+	api, restore := newSDL(t, server, "2", "SRR1")
+	defer restore()
+
+	accs, err := api.RetrieveAllInBatch(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 1 || accs[0].Files["a.cram"].Link != "" {
+		t.Errorf("accessions = %v, want SRR1 without links", accs)
+	}
+	rr := server.Requests()
+	if len(rr) != 1 || rr[0].Fields["meta-only"] != "yes" {
+		t.Errorf("requests = %v, want one asking for meta-only", rr)
+	}
+}
+
+func TestAccessionErrorsAreKept(t *testing.T) {
+	server := sdltest.NewServer()
+	defer server.Close()
+	server.SetStatus("SRR1", http.StatusForbidden, "Access denied")
+	api, restore := newSDL(t, server, "2", "SRR1")
+	defer restore()
+
+	accs, err := api.SignAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accs) != 1 || !accs[0].HasError() {
+		t.Fatalf("accessions = %v, want SRR1 with an error", accs)
+	}
+	if !strings.Contains(accs[0].ErrorLog(), "Access denied") {
+		t.Errorf("error log = %q, want the message the SDL API gave", accs[0].ErrorLog())
+	}
+}
@@ -0,0 +1,180 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/client"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// cart The accessions asked for and how to read their files.
+type cart struct {
+	accessions []*fuseralib.Accession
+	types      map[string]bool
+	// reader Reads files the same way fusera does, so requester pays and
+	// compute environment required files copy as well as they mount.
+	reader *fuseralib.Reader
+}
+
+// openCart Asks the SDL API for accs, or the accessions given by flag if accs is nil,
+// going by the token, file type, and location flags every sracp command shares.
+// With metaOnly, only their metadata is asked for, so the cart's files have no links to read them with.
+// What it has to say goes to stderr, since stdout may be carrying a file, as with cat.
+func openCart(accs []string, metaOnly bool) (*cart, error) {
+	policy, err := fuseralib.ParseRegionPolicy(flags.RegionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(accs)
+	if err != nil {
+		return nil, err
+	}
+	region, err := c.Locator.Region()
+	if err != nil {
+		twig.Debug(err)
+		if !flags.Silent {
+			fmt.Fprintln(os.Stderr, "It seems like sracp is encountering errors resolving its region, shutting down.")
+		}
+		return nil, err
+	}
+
+	if flags.Verbose {
+		c.Describe(os.Stderr)
+	}
+	var accessions []*fuseralib.Accession
+	if metaOnly {
+		accessions, err = fuseralib.RetrieveAccessions(c.API, c.Accessions, flags.Batch)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var warnings error
+		accessions, warnings = fuseralib.FetchAccessions(c.API, c.Accessions, flags.Batch)
+		if warnings != nil {
+			if !flags.Silent {
+				fmt.Fprintln(os.Stderr, warnings.Error())
+			}
+		}
+	}
+	if len(accessions) == 0 {
+		return nil, errors.New("none of the accessions were successful, sracp is shutting down")
+	}
+	reader := fuseralib.NewReader(c.API, region, flags.SetProfile(c.Locator.SdlCloudName()))
+	reader.Guard = fuseralib.NewRegionGuard(policy, c.Locator.SdlCloudName(), region)
+	return &cart{
+		accessions: accessions,
+		types:      c.Types,
+		reader:     reader,
+	}, nil
+}
+
+// outcome What became of every accession and file in a run.
+type outcome struct {
+	records  []record
+	failures []string
+}
+
+func (o *outcome) add(rec record) {
+	o.records = append(o.records, rec)
+	if rec.Status != statusFailed {
+		return
+	}
+	if rec.Name == "" {
+		o.failures = append(o.failures, fmt.Sprintf("%s: %s", rec.Accession, rec.Error))
+		return
+	}
+	o.failures = append(o.failures, fmt.Sprintf("%s/%s: %s", rec.Accession, rec.Name, rec.Error))
+}
+
+// err Returns an error listing every failure, if there were any.
+func (o *outcome) err(what string) error {
+	if len(o.failures) == 0 {
+		return nil
+	}
+	return errors.Errorf("%d %s failed:\n%s", len(o.failures), what, strings.Join(o.failures, "\n"))
+}
+
+// jobs Returns a job for every file in the cart of the types asked for, placed under dest by lay.
+// Accessions that failed, and files that can't be placed or that the region policy refuses, are recorded in o as failures;
+// an accession's other files are still copied.
+func (c *cart) jobs(lay *layout, dest string, p *progress, o *outcome) []job {
+	var jobs []job
+	for _, a := range c.accessions {
+		if a.HasError() {
+			p.failAccession(a.ID, a.ErrorLog())
+			o.add(record{Accession: a.ID, Status: statusFailed, Error: a.ErrorLog()})
+			continue
+		}
+		files := make([]job, 0, len(a.Files))
+		failed := 0
+		for _, f := range a.Files {
+			// if the API returns filetypes the user didn't want, still don't copy them.
+			if c.types != nil {
+				if _, ok := c.types[f.Type]; !ok {
+					continue
+				}
+			}
+			// Files the region policy refuses to read fail up front instead of when they're reached.
+			if err := c.reader.Guard.Check(a.ID, f); err != nil {
+				p.failFile(a.ID, f.Name, err.Error())
+				o.add(record{Accession: a.ID, Name: f.Name, Status: statusFailed, Error: err.Error()})
+				failed++
+				continue
+			}
+			path, err := lay.path(dest, a.ID, f)
+			if err != nil {
+				p.failFile(a.ID, f.Name, err.Error())
+				o.add(record{Accession: a.ID, Name: f.Name, Status: statusFailed, Error: err.Error()})
+				failed++
+				continue
+			}
+			files = append(files, job{acc: a.ID, file: f, path: path})
+		}
+		if len(files) == 0 {
+			if failed > 0 {
+				p.endAccession(a.ID)
+			} else {
+				p.skipAccession(a.ID)
+			}
+			continue
+		}
+		jobs = append(jobs, files...)
+	}
+	return jobs
+}
+
+// runJobs Runs every job across concurrency workers, showing progress with p and recording how each went in o.
+func runJobs(jobs []job, p *progress, o *outcome, run func(job) (bool, error)) {
+	p.queue(jobs)
+	stop := make(chan struct{})
+	go p.report(stop)
+	for r := range schedule(jobs, flags.Concurrency, func(j job) (bool, error) {
+		p.start(j)
+		return run(j)
+	}) {
+		o.add(p.finish(r))
+	}
+	close(stop)
+	p.close()
+}
@@ -0,0 +1,101 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/mitre/fusera/fuseralib"
+)
+
+// events Returns the json progress events written to out.
+func events(t *testing.T, out *bytes.Buffer) []event {
+	t.Helper()
+	var evs []event
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		ev := event{record: &record{}, tally: &tally{}, transfer: &transfer{}}
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestFileThatCantBePlacedFailsAlone(t *testing.T) {
+	c := &cart{
+		accessions: []*fuseralib.Accession{{ID: "SRR1", Files: map[string]fuseralib.File{
+			"a.cram":  {Name: "a.cram", Md5Hash: "b1946ac92492d2347c6235b4d2611184"},
+			"b.bam":   {Name: "b.bam"},
+			"c.fastq": {Name: "c.fastq", Md5Hash: "a75811dbc34fc743f45aa94d4febe431"},
+		}}},
+		reader: &fuseralib.Reader{},
+	}
+	lay, err := parseLayout("{accession}/{md5}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	p := newProgress(progressJSON, &out)
+	o := &outcome{}
+	jobs := c.jobs(lay, "/dest", p, o)
+
+	if len(jobs) != 2 {
+		t.Fatalf("%d jobs, want a.cram and c.fastq still copied", len(jobs))
+	}
+	if len(o.failures) != 1 || o.records[0].Name != "b.bam" {
+		t.Errorf("failures = %v, want only b.bam", o.failures)
+	}
+	evs := events(t, &out)
+	if len(evs) != 1 || evs[0].Event != "finish" || evs[0].Name != "b.bam" || evs[0].Status != statusFailed {
+		t.Fatalf("events = %+v, want only b.bam failing", evs)
+	}
+
+	p.queue(jobs)
+	for _, j := range jobs {
+		p.finish(result{job: j})
+	}
+	evs = events(t, &out)
+	last := evs[len(evs)-1]
+	if last.Event != "accession" || last.Files != 3 || last.Downloaded != 2 || last.Failed != 1 {
+		t.Errorf("accession ended with %+v, want 2 of 3 files downloaded and 1 failed", last.tally)
+	}
+}
+
+func TestAccessionWithEveryFileFailedEnds(t *testing.T) {
+	c := &cart{
+		accessions: []*fuseralib.Accession{{ID: "SRR1", Files: map[string]fuseralib.File{
+			"b.bam": {Name: "b.bam"},
+		}}},
+		reader: &fuseralib.Reader{},
+	}
+	lay, err := parseLayout("{accession}/{md5}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	jobs := c.jobs(lay, "/dest", newProgress(progressJSON, &out), &outcome{})
+	if len(jobs) != 0 {
+		t.Fatalf("%d jobs, want none", len(jobs))
+	}
+	evs := events(t, &out)
+	if len(evs) != 2 || evs[1].Event != "accession" || evs[1].Files != 1 || evs[1].Failed != 1 {
+		t.Errorf("events = %+v, want b.bam failing and then the accession ending", evs)
+	}
+}
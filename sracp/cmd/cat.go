@@ -0,0 +1,145 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(catCmd)
+}
+
+var catCmd = &cobra.Command{
+	Use:   "cat <accession>/<file>",
+	Short: "Write a single file to stdout, for piping straight into another tool.",
+	Long: `Write a single file to stdout, for piping straight into another tool without touching local disk.
+Interrupted downloads pick up where they left off, and the md5 of everything written is checked once it's done.
+Since stdout is for the file, nothing else is printed unless something goes wrong.`,
+	Example: "sracp cat SRR1234567/SRR1234567.cram | samtools view -",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		parts := strings.SplitN(args[0], "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.Errorf("expected <accession>/<file>, got: %s", args[0])
+		}
+		acc, name := parts[0], parts[1]
+		// Anything printed along the way would end up in the middle of the file.
+		flags.Silent = true
+		flags.Verbose = false
+		c, err := openCart([]string{acc}, false)
+		if err != nil {
+			return err
+		}
+		f, err := c.file(acc, name)
+		if err != nil {
+			return err
+		}
+		return stream(c.reader, acc, f, os.Stdout)
+	},
+}
+
+// file Returns the file of acc called name.
+func (c *cart) file(acc, name string) (fuseralib.File, error) {
+	for _, a := range c.accessions {
+		if a.ID != acc {
+			continue
+		}
+		if a.HasError() {
+			return fuseralib.File{}, errors.Errorf("accession %s failed: %s", acc, a.ErrorLog())
+		}
+		if f, ok := a.Files[name]; ok {
+			return f, nil
+		}
+		names := make([]string, 0, len(a.Files))
+		for n := range a.Files {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fuseralib.File{}, errors.Errorf("accession %s has no file: %s, its files are: %s", acc, name, strings.Join(names, ", "))
+	}
+	return fuseralib.File{}, errors.Errorf("SDL API didn't return accession: %s", acc)
+}
+
+// stream Writes f to w, picking up where it left off when interrupted, up to retries times,
+// and checks the size and md5 of everything written once it's done.
+func stream(reader *fuseralib.Reader, acc string, f fuseralib.File, w io.Writer) error {
+	h := md5.New()
+	out := &stickyWriter{w: io.MultiWriter(w, h)}
+	var written int64
+	var err error
+	for attempt := 0; attempt <= flags.Retries; attempt++ {
+		if attempt > 0 {
+			twig.Debugf("retrying %s from byte %d, attempt %d of %d: %s", f.Name, written, attempt, flags.Retries, err.Error())
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var body io.ReadCloser
+		body, err = reader.OpenRange(acc, &f, written)
+		if err != nil {
+			err = errors.Wrapf(err, "couldn't start reading %s", f.Name)
+			continue
+		}
+		var n int64
+		n, err = io.Copy(out, body)
+		body.Close()
+		written += n
+		if out.err != nil {
+			// Whatever is reading the other end has gone away, trying again won't help.
+			return errors.Wrapf(out.err, "couldn't write %s", f.Name)
+		}
+		if err == nil {
+			break
+		}
+		err = errors.Wrapf(err, "reading %s was interrupted", f.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if f.Size > 0 && uint64(written) != f.Size {
+		return errors.Errorf("size mismatch for %s: expected %d bytes, wrote %d bytes", f.Name, f.Size, written)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); f.Md5Hash != "" && sum != f.Md5Hash {
+		return errors.Errorf("md5 mismatch for %s: expected %s, wrote %s", f.Name, f.Md5Hash, sum)
+	}
+	return nil
+}
+
+// stickyWriter Remembers the first error writing to w, to tell failures to write apart from failures to read.
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
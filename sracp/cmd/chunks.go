@@ -0,0 +1,245 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// chunkStateSuffix The chunks of a partial download that are finished are kept next to it under this suffix,
+// so a chunked download can be resumed.
+const chunkStateSuffix = ".sracp-chunks"
+
+// chunkState Which chunks of a partial download are on disk.
+type chunkState struct {
+	Size      uint64 `json:"size"`
+	Md5Hash   string `json:"md5,omitempty"`
+	ChunkSize int64  `json:"chunkSize"`
+	Done      []bool `json:"done"`
+}
+
+func newChunkState(f *fuseralib.File, chunkSize int64) *chunkState {
+	n := (int64(f.Size) + chunkSize - 1) / chunkSize
+	return &chunkState{
+		Size:      f.Size,
+		Md5Hash:   f.Md5Hash,
+		ChunkSize: chunkSize,
+		Done:      make([]bool, n),
+	}
+}
+
+// matches Returns true if the state was saved for f, split into chunks of chunkSize.
+func (s *chunkState) matches(f *fuseralib.File, chunkSize int64) bool {
+	return s.Size == f.Size && s.Md5Hash == f.Md5Hash && s.ChunkSize == chunkSize && len(s.Done) == len(newChunkState(f, chunkSize).Done)
+}
+
+// bounds Returns where chunk i starts and how long it is.
+func (s *chunkState) bounds(i int) (int64, int64) {
+	offset := int64(i) * s.ChunkSize
+	length := s.ChunkSize
+	if offset+length > int64(s.Size) {
+		length = int64(s.Size) - offset
+	}
+	return offset, length
+}
+
+// doneBytes Returns how many bytes of the file are in finished chunks.
+func (s *chunkState) doneBytes() int64 {
+	var n int64
+	for i, done := range s.Done {
+		if done {
+			_, length := s.bounds(i)
+			n += length
+		}
+	}
+	return n
+}
+
+func loadChunkState(path string) (*chunkState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &chunkState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *chunkState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode chunk state")
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "couldn't write chunk state: %s", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "couldn't write chunk state: %s", path)
+	}
+	return nil
+}
+
+// chunked Returns true if f is big enough to be split into chunks downloaded over several connections.
+func (c *copier) chunked(f fuseralib.File) bool {
+	return c.connections > 1 && c.chunkSize > 0 && f.Size > uint64(c.chunkSize)
+}
+
+// fetchChunks Downloads f into path a chunk at a time over several connections, each chunk written where it belongs in
+// a file made the full size of f up front. Finished chunks are recorded next to path so they aren't downloaded again.
+// Each chunk is tried again up to retries times before giving up on the whole file.
+func (c *copier) fetchChunks(acc string, f *fuseralib.File, path string) error {
+	statePath := path + chunkStateSuffix
+	state, err := loadChunkState(statePath)
+	switch {
+	case err == nil && state.matches(f, c.chunkSize):
+	case err == nil:
+		// Chunks of some other file, or split some other way.
+		state = newChunkState(f, c.chunkSize)
+		os.Remove(path)
+	default:
+		state = newChunkState(f, c.chunkSize)
+		if fi, err := os.Stat(path); err == nil && uint64(fi.Size()) <= state.Size {
+			// Left over from a download that wasn't chunked, whose bytes are all at the start.
+			for i := range state.Done {
+				offset, length := state.bounds(i)
+				state.Done[i] = offset+length <= fi.Size()
+			}
+		} else if err == nil {
+			os.Remove(path)
+		}
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open file to download into: %s", path)
+	}
+	defer out.Close()
+	// Holes in a sparse file take no space until they're written.
+	if err := out.Truncate(int64(f.Size)); err != nil {
+		return errors.Wrapf(err, "couldn't allocate file to download into: %s", path)
+	}
+	if err := state.save(statePath); err != nil {
+		return err
+	}
+
+	key := jobKey(acc, f.Name)
+	c.progress.set(key, state.doneBytes())
+	// Renew the link once up front rather than in every chunk at once.
+	if err := c.reader.Renew(acc, f); err != nil {
+		return errors.Wrapf(err, "couldn't start download of %s", f.Name)
+	}
+
+	pending := make(chan int)
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < c.connections; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every connection works with its own copy, so renewing a link doesn't race.
+			file := *f
+			for i := range pending {
+				err := c.fetchChunk(acc, &file, out, state, i)
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					state.Done[i] = true
+					if serr := state.save(statePath); serr != nil && firstErr == nil {
+						firstErr = serr
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i, done := range state.Done {
+		if !done {
+			pending <- i
+		}
+	}
+	close(pending)
+	wg.Wait()
+	return firstErr
+}
+
+// fetchChunk Downloads chunk i of f into out, trying again up to retries times.
+func (c *copier) fetchChunk(acc string, f *fuseralib.File, out *os.File, state *chunkState, i int) error {
+	offset, length := state.bounds(i)
+	key := jobKey(acc, f.Name)
+	var err error
+	for attempt := 0; attempt <= flags.Retries; attempt++ {
+		if attempt > 0 {
+			twig.Debugf("retrying chunk %d of %s, attempt %d of %d: %s", i, f.Name, attempt, flags.Retries, err.Error())
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		var written int64
+		written, err = c.copyChunk(acc, f, out, offset, length)
+		if err == nil {
+			return nil
+		}
+		// Those bytes will be downloaded again.
+		c.progress.discard(key, written)
+	}
+	return errors.Wrapf(err, "chunk %d of %s failed", i, f.Name)
+}
+
+func (c *copier) copyChunk(acc string, f *fuseralib.File, out *os.File, offset, length int64) (int64, error) {
+	body, err := c.reader.OpenSection(acc, f, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	in := &meter{r: body, key: jobKey(acc, f.Name), limiter: c.limiter, progress: c.progress}
+	written, err := io.Copy(&offsetWriter{w: out, offset: offset}, in)
+	if err != nil {
+		return written, errors.Wrapf(err, "download of %s was interrupted", f.Name)
+	}
+	if written != length {
+		return written, errors.Errorf("download of %s was cut short: got %d of %d bytes at offset %d", f.Name, written, length, offset)
+	}
+	return written, nil
+}
+
+// offsetWriter Writes to w one after another starting at offset, so chunks can be written at the same time.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,172 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// partialSuffix Files are downloaded under this suffix and only renamed once they've been verified,
+// so a file without it is always a complete copy.
+const partialSuffix = ".sracp-partial"
+
+// copier Holds what every download shares, no matter which worker it runs on.
+type copier struct {
+	reader *fuseralib.Reader
+	// limiter Caps the combined rate of all downloads, nil when there's no cap.
+	limiter  *limiter
+	progress *progress
+	// connections How many chunks of a large file to download at once.
+	connections int
+	// chunkSize How big each chunk of a large file is. Files no bigger are downloaded over one connection.
+	chunkSize int64
+}
+
+// download Copies f to path, verifying its size and md5.
+// A complete copy already at path is left alone, and a partial download from an earlier run is resumed.
+// Failed or corrupt downloads are tried again up to retries times.
+func (c *copier) download(acc string, f fuseralib.File, path string) (skipped bool, err error) {
+	if complete(f, path) {
+		c.progress.set(jobKey(acc, f.Name), int64(f.Size))
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, errors.Wrapf(err, "couldn't create directory for: %s", path)
+	}
+	partial := path + partialSuffix
+	for attempt := 0; attempt <= flags.Retries; attempt++ {
+		if attempt > 0 {
+			twig.Debugf("retrying %s, attempt %d of %d: %s", f.Name, attempt, flags.Retries, err.Error())
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if c.chunked(f) {
+			err = c.fetchChunks(acc, &f, partial)
+		} else {
+			err = c.fetch(acc, &f, partial)
+		}
+		if err != nil {
+			continue
+		}
+		err = verify(f, partial)
+		if err != nil {
+			// Resuming a corrupt file would only keep it corrupt.
+			os.Remove(partial)
+			os.Remove(partial + chunkStateSuffix)
+			continue
+		}
+		os.Remove(partial + chunkStateSuffix)
+		if err = os.Rename(partial, path); err != nil {
+			return false, errors.Wrapf(err, "couldn't move finished download into place: %s", path)
+		}
+		return false, nil
+	}
+	return false, err
+}
+
+// complete Returns true if path already holds a copy of f with the right size and md5.
+func complete(f fuseralib.File, path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	return verify(f, path) == nil
+}
+
+// fetch Downloads f into path, picking up where any bytes already in path left off.
+// f's link is renewed in place if it has expired.
+func (c *copier) fetch(acc string, f *fuseralib.File, path string) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't open file to download into: %s", path)
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't seek to end of partial download: %s", path)
+	}
+	if _, err := os.Stat(path + chunkStateSuffix); err == nil {
+		// Left over from a chunked download, whose bytes aren't all at the start.
+		if err := restart(out); err != nil {
+			return err
+		}
+		os.Remove(path + chunkStateSuffix)
+		offset = 0
+	}
+	if f.Size > 0 && uint64(offset) > f.Size {
+		// Whatever this is, it isn't a partial copy of f.
+		if err := restart(out); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	c.progress.set(jobKey(acc, f.Name), offset)
+	if f.Size > 0 && uint64(offset) == f.Size {
+		return nil
+	}
+	if offset > 0 {
+		twig.Debugf("resuming %s from byte %d", f.Name, offset)
+	}
+	body, err := c.reader.OpenRange(acc, f, offset)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't start download of %s", f.Name)
+	}
+	defer body.Close()
+	in := &meter{r: body, key: jobKey(acc, f.Name), limiter: c.limiter, progress: c.progress}
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Wrapf(err, "download of %s was interrupted", f.Name)
+	}
+	return nil
+}
+
+func restart(out *os.File) error {
+	if err := out.Truncate(0); err != nil {
+		return errors.Wrapf(err, "couldn't truncate partial download: %s", out.Name())
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "couldn't seek to start of partial download: %s", out.Name())
+	}
+	return nil
+}
+
+// verify Returns an error if the file at path doesn't have the size and md5 the SDL API gave for f.
+func verify(f fuseralib.File, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't stat downloaded file: %s", path)
+	}
+	if f.Size > 0 && uint64(fi.Size()) != f.Size {
+		return errors.Errorf("size mismatch for %s: expected %d bytes, got %d bytes", f.Name, f.Size, fi.Size())
+	}
+	if f.Md5Hash == "" {
+		return nil
+	}
+	sum, err := fuseralib.Md5File(path)
+	if err != nil {
+		return err
+	}
+	if sum != f.Md5Hash {
+		return errors.Errorf("md5 mismatch for %s: expected %s, got %s", f.Name, f.Md5Hash, sum)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/mitre/fusera/gps"
+	"github.com/mitre/fusera/info"
+	"github.com/mitre/fusera/mock/sdltest"
+	"github.com/mitre/fusera/sdl"
+	"github.com/pkg/errors"
+)
+
+var bam = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+// serve Starts a fake SDL API serving SRR1 with a.bam, and returns a copier reading from it over one connection,
+// and a.bam as signed for SRR1. Close the server when done.
+func serve(t *testing.T) (*sdltest.Server, *copier, fuseralib.File) {
+	t.Helper()
+	server := sdltest.NewServer()
+	if err := server.AddAccession("SRR1", sdltest.File{Name: "a.bam", Type: "bam", Data: bam}); err != nil {
+		t.Fatal(err)
+	}
+	location, err := gps.NewManualLocation("s3.us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags.Silent = true
+	flags.Retries = 0
+	info.LoadAccessionMap([]string{"SRR1"})
+	api := sdl.NewSDL()
+	api.URL = server.URL()
+	api.Param = sdl.NewParam([]string{"SRR1"}, location, nil, "", nil)
+	acc, err := api.Sign("SRR1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := acc.Files["a.bam"]
+	if !ok {
+		t.Fatalf("SRR1 has no a.bam: %s", acc.ErrorLog())
+	}
+	c := &copier{
+		reader:      fuseralib.NewReader(api, "", ""),
+		progress:    newProgress(progressNone, ioutil.Discard),
+		connections: 1,
+	}
+	return server, c, f
+}
+
+// dest Returns where a.bam goes in a new temporary directory, removed by the returned func.
+func dest(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sracp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "SRR1", "a.bam"), func() { os.RemoveAll(dir) }
+}
+
+// downloaded Fails t unless path holds all of a.bam and nothing of the download is left beside it.
+func downloaded(t *testing.T, path string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bam) {
+		t.Errorf("%s has %d bytes, not a.bam's %d", path, len(data), len(bam))
+	}
+	for _, leftover := range []string{path + partialSuffix, path + partialSuffix + chunkStateSuffix} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind", leftover)
+		}
+	}
+}
+
+func TestDownloadIsSkippedOnceComplete(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+
+	if skipped, err := c.download("SRR1", f, path); skipped || err != nil {
+		t.Fatalf("download = %v, %v", skipped, err)
+	}
+	downloaded(t, path)
+	if skipped, err := c.download("SRR1", f, path); !skipped || err != nil {
+		t.Errorf("second download = %v, %v, want it skipped", skipped, err)
+	}
+	if n := server.Store.Gets("SRR1/a.bam"); n != 1 {
+		t.Errorf("a.bam was got %d times, want 1", n)
+	}
+}
+
+func TestDownloadResumesPartial(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path+partialSuffix, bam[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.download("SRR1", f, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded(t, path)
+	if n := c.progress.transferred; n != int64(len(bam)-1000) {
+		t.Errorf("%d bytes were downloaded, want the %d after the partial", n, len(bam)-1000)
+	}
+}
+
+func TestCorruptDownloadIsThrownAway(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	corrupt := append([]byte(nil), bam...)
+	corrupt[100] = 'x'
+	server.Store.Put("SRR1/a.bam", corrupt)
+	flags.Retries = 1
+
+	_, err := c.download("SRR1", f, path)
+	if err == nil {
+		t.Fatal("download of corrupt a.bam didn't fail")
+	}
+	if n := server.Store.Gets("SRR1/a.bam"); n != 2 {
+		t.Errorf("a.bam was got %d times, want 2: once and once more from the start", n)
+	}
+	for _, p := range []string{path, path + partialSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s was kept", p)
+		}
+	}
+}
+
+func TestTruncatedDownloadPicksUpWhereItLeftOff(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	server.Store.Fail("SRR1/a.bam", sdltest.Truncated)
+	flags.Retries = 1
+
+	if _, err := c.download("SRR1", f, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded(t, path)
+	if n := server.Store.Gets("SRR1/a.bam"); n != 2 {
+		t.Errorf("a.bam was got %d times, want 2", n)
+	}
+	if n := c.progress.transferred; n != int64(len(bam)) {
+		t.Errorf("%d bytes were downloaded, want each of a.bam's %d once", n, len(bam))
+	}
+}
+
+func TestExpiredLinkIsRenewed(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	server.Store.Fail("SRR1/a.bam", sdltest.Expired)
+
+	if _, err := c.download("SRR1", f, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded(t, path)
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("SDL API was asked %d times, want 2: to sign and to renew", n)
+	}
+}
+
+func TestForbiddenDownloadFails(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	server.Store.Fail("SRR1/a.bam", sdltest.Forbidden, sdltest.Forbidden)
+
+	_, err := c.download("SRR1", f, path)
+	if errors.Cause(err) != syscall.EACCES {
+		t.Fatalf("err = %v, want EACCES", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was made", path)
+	}
+}
+
+func TestChunkedDownloadResumesUnfinishedChunks(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	c.connections, c.chunkSize = 3, 16*1024
+	// Chunks 0 and 2 of 4 made it to disk last time.
+	state := newChunkState(&f, c.chunkSize)
+	partial := make([]byte, len(bam))
+	for _, i := range []int{0, 2} {
+		offset, length := state.bounds(i)
+		copy(partial[offset:offset+length], bam[offset:offset+length])
+		state.Done[i] = true
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path+partialSuffix, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(path + partialSuffix + chunkStateSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.download("SRR1", f, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded(t, path)
+	if n := server.Store.Gets("SRR1/a.bam"); n != 2 {
+		t.Errorf("a.bam was got %d times, want 2: for chunks 1 and 3", n)
+	}
+}
+
+func TestChunkStateOfOtherFileIsStartedOver(t *testing.T) {
+	server, c, f := serve(t)
+	defer server.Close()
+	path, cleanup := dest(t)
+	defer cleanup()
+	c.connections, c.chunkSize = 2, 16*1024
+	other := f
+	other.Md5Hash = "b1946ac92492d2347c6235b4d2611184"
+	state := newChunkState(&other, c.chunkSize)
+	for i := range state.Done {
+		state.Done[i] = true
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path+partialSuffix, make([]byte, len(bam)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.save(path + partialSuffix + chunkStateSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.download("SRR1", f, path); err != nil {
+		t.Fatal(err)
+	}
+	downloaded(t, path)
+	if n := server.Store.Gets("SRR1/a.bam"); n != 4 {
+		t.Errorf("a.bam was got %d times, want 4: every chunk", n)
+	}
+}
+
+func TestChunkState(t *testing.T) {
+	f := &fuseralib.File{Size: 10, Md5Hash: "b1946ac92492d2347c6235b4d2611184"}
+	s := newChunkState(f, 4)
+	if len(s.Done) != 3 {
+		t.Fatalf("%d chunks, want 3", len(s.Done))
+	}
+	for i, want := range [][2]int64{{0, 4}, {4, 4}, {8, 2}} {
+		if offset, length := s.bounds(i); offset != want[0] || length != want[1] {
+			t.Errorf("chunk %d is %d bytes at %d, want %d at %d", i, length, offset, want[1], want[0])
+		}
+	}
+	s.Done[0], s.Done[2] = true, true
+	if n := s.doneBytes(); n != 6 {
+		t.Errorf("%d bytes done, want 6", n)
+	}
+	other := *f
+	other.Md5Hash = "a75811dbc34fc743f45aa94d4febe431"
+	tests := []struct {
+		f         *fuseralib.File
+		chunkSize int64
+		want      bool
+	}{
+		{f, 4, true},
+		{f, 5, false},
+		{&other, 4, false},
+		{&fuseralib.File{Size: 11, Md5Hash: f.Md5Hash}, 4, false},
+	}
+	for _, tt := range tests {
+		if got := s.matches(tt.f, tt.chunkSize); got != tt.want {
+			t.Errorf("matches(%+v, %d) = %v, want %v", tt.f, tt.chunkSize, got, tt.want)
+		}
+	}
+}
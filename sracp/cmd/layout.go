@@ -0,0 +1,165 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// The placeholders a layout can use.
+var placeholders = map[string]func(acc string, f fuseralib.File) string{
+	"accession": func(acc string, f fuseralib.File) string { return acc },
+	"name":      func(acc string, f fuseralib.File) string { return f.Name },
+	"type":      func(acc string, f fuseralib.File) string { return f.Type },
+	"md5":       func(acc string, f fuseralib.File) string { return f.Md5Hash },
+}
+
+// layout A template for where each file goes under the destination, such as {type}/{accession}_{name}.
+// A placeholder can be cut down to its first n characters with {placeholder:n}, to shard files into directories by prefix.
+type layout struct {
+	segments []segment
+}
+
+// segment Either literal text or a placeholder.
+type segment struct {
+	literal string
+	field   string
+	// prefix How many characters of the placeholder to keep, 0 for all of them.
+	prefix int
+}
+
+// parseLayout Returns the layout described by template, or an error naming what's wrong with it.
+func parseLayout(template string) (*layout, error) {
+	if template == "" {
+		// Where sracp has always put files: a directory per accession.
+		template = flags.LayoutDefault
+	}
+	if clean := filepath.Clean(template); filepath.IsAbs(template) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, errors.Errorf("layout must be relative to the destination: %s", template)
+	}
+	l := &layout{}
+	rest := template
+	for rest != "" {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			l.segments = append(l.segments, segment{literal: rest})
+			break
+		}
+		if open > 0 {
+			l.segments = append(l.segments, segment{literal: rest[:open]})
+		}
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			return nil, errors.Errorf("layout has an unclosed placeholder: %s", template)
+		}
+		s, err := parsePlaceholder(rest[open+1 : open+end])
+		if err != nil {
+			return nil, errors.Wrapf(err, "layout: %s", template)
+		}
+		l.segments = append(l.segments, s)
+		rest = rest[open+end+1:]
+	}
+	for _, s := range l.segments {
+		if s.field == "name" || s.field == "md5" {
+			return l, nil
+		}
+	}
+	return nil, errors.Errorf("layout must use {name} or {md5} so files don't all land in the same place: %s", template)
+}
+
+func parsePlaceholder(p string) (segment, error) {
+	parts := strings.SplitN(p, ":", 2)
+	s := segment{field: parts[0]}
+	if _, ok := placeholders[s.field]; !ok {
+		return s, errors.Errorf("unknown placeholder {%s}, must be one of %s", p, strings.Join(placeholderNames(), ", "))
+	}
+	if len(parts) == 2 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return s, errors.Errorf("placeholder {%s} must be cut down to a positive number of characters", p)
+		}
+		s.prefix = n
+	}
+	return s, nil
+}
+
+func placeholderNames() []string {
+	names := make([]string, 0, len(placeholders))
+	for name := range placeholders {
+		names = append(names, "{"+name+"}")
+	}
+	sort.Strings(names)
+	return names
+}
+
+// path Returns where f, a file of acc, goes under dest.
+func (l *layout) path(dest, acc string, f fuseralib.File) (string, error) {
+	var b strings.Builder
+	for _, s := range l.segments {
+		if s.field == "" {
+			b.WriteString(s.literal)
+			continue
+		}
+		value := placeholders[s.field](acc, f)
+		if value == "" {
+			return "", errors.Errorf("layout uses {%s} but %s/%s has none", s.field, acc, f.Name)
+		}
+		if s.prefix > 0 && s.prefix < len(value) {
+			value = value[:s.prefix]
+		}
+		// Values come from the SDL API, don't let them reach outside the destination.
+		value = strings.Replace(value, "/", "_", -1)
+		if value == "." || value == ".." {
+			value = strings.Replace(value, ".", "_", -1)
+		}
+		b.WriteString(value)
+	}
+	rel := filepath.Clean(b.String())
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("layout puts %s/%s outside the destination: %s", acc, f.Name, rel)
+	}
+	return filepath.Join(dest, rel), nil
+}
+
+// checkCollisions Returns an error listing every path more than one job would be written to.
+func checkCollisions(jobs []job) error {
+	byPath := make(map[string][]string)
+	var order []string
+	for _, j := range jobs {
+		if _, ok := byPath[j.path]; !ok {
+			order = append(order, j.path)
+		}
+		byPath[j.path] = append(byPath[j.path], jobKey(j.acc, j.file.Name))
+	}
+	var collisions []string
+	for _, path := range order {
+		if files := byPath[path]; len(files) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s: %s", path, strings.Join(files, ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		return errors.Errorf("layout puts more than one file in the same place:\n%s", strings.Join(collisions, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitre/fusera/fuseralib"
+)
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		template string
+		// err Part of the error expected, "" if the layout is fine.
+		err string
+	}{
+		{"", ""},
+		{"{accession}/{name}", ""},
+		{"{type}/{accession}_{name}", ""},
+		{"{md5:2}/{md5}", ""},
+		{"flat/{name}", ""},
+		{"{accession}", "must use {name} or {md5}"},
+		{"/abs/{name}", "must be relative"},
+		{"../{name}", "must be relative"},
+		{"..", "must be relative"},
+		{"{accession/{name}", "unknown placeholder"},
+		{"{accession}/{name", "unclosed placeholder"},
+		{"{size}/{name}", "unknown placeholder"},
+		{"{md5:0}/{name}", "positive number"},
+		{"{md5:x}/{name}", "positive number"},
+	}
+	for _, tt := range tests {
+		_, err := parseLayout(tt.template)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("parseLayout(%q) = %v, want no error", tt.template, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("parseLayout(%q) = %v, want an error saying %q", tt.template, err, tt.err)
+		}
+	}
+}
+
+func TestLayoutPath(t *testing.T) {
+	f := fuseralib.File{Name: "a.cram", Type: "cram", Md5Hash: "b1946ac92492d2347c6235b4d2611184"}
+	tests := []struct {
+		template string
+		f        fuseralib.File
+		want     string
+		err      string
+	}{
+		{"", f, "/dest/SRR1/a.cram", ""},
+		{"{type}/{accession}_{name}", f, "/dest/cram/SRR1_a.cram", ""},
+		{"{md5:2}/{md5}", f, "/dest/b1/b1946ac92492d2347c6235b4d2611184", ""},
+		{"{name:10}/{name}", f, "/dest/a.cram/a.cram", ""},
+		{"{accession}/{name}", fuseralib.File{Name: "../../etc/passwd"}, "/dest/SRR1/.._.._etc_passwd", ""},
+		{"{name}", fuseralib.File{Name: ".."}, "/dest/__", ""},
+		{"{md5}", fuseralib.File{Name: "b.bam"}, "", "has none"},
+	}
+	for _, tt := range tests {
+		lay, err := parseLayout(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := lay.path("/dest", "SRR1", tt.f)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: err = %v, want an error saying %q", tt.template, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || path != tt.want {
+			t.Errorf("%q placed %s at %q, %v, want %q", tt.template, tt.f.Name, path, err, tt.want)
+		}
+	}
+}
+
+func TestCheckCollisions(t *testing.T) {
+	jobs := []job{
+		{acc: "SRR1", file: fuseralib.File{Name: "a.cram"}, path: "/dest/a.cram"},
+		{acc: "SRR2", file: fuseralib.File{Name: "a.cram"}, path: "/dest/a.cram"},
+		{acc: "SRR1", file: fuseralib.File{Name: "b.bam"}, path: "/dest/b.bam"},
+	}
+	if err := checkCollisions(jobs[1:]); err != nil {
+		t.Errorf("err = %v, want none for files in different places", err)
+	}
+	err := checkCollisions(jobs)
+	if err == nil || !strings.Contains(err.Error(), "/dest/a.cram: SRR1/a.cram, SRR2/a.cram") {
+		t.Errorf("err = %v, want SRR1/a.cram and SRR2/a.cram named as colliding", err)
+	}
+	if strings.Contains(err.Error(), "b.bam") {
+		t.Errorf("err = %v, want b.bam left out", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// plan What downloading the cart will take.
+type plan struct {
+	jobs []job
+	// pending Bytes each job has left to download, by index into jobs.
+	pending []uint64
+	// total Bytes of every file in the cart.
+	total uint64
+	// needed Bytes of disk the cart still needs, leaving out files already present and bytes of partial downloads.
+	needed uint64
+	// available Bytes free on the filesystem the cart is going to.
+	available uint64
+}
+
+// newPlan Works out how much of each job is left to download and whether it fits on the filesystem holding dest.
+func newPlan(jobs []job, dest string) (*plan, error) {
+	available, err := availableBytes(dest)
+	if err != nil {
+		return nil, err
+	}
+	p := &plan{
+		jobs:      jobs,
+		pending:   make([]uint64, len(jobs)),
+		available: available,
+	}
+	for i, j := range jobs {
+		p.pending[i] = pendingBytes(j)
+		p.total += j.file.Size
+		p.needed += p.pending[i]
+	}
+	return p, nil
+}
+
+// fits Returns an error if the cart needs more space than is available.
+func (p *plan) fits() error {
+	if p.needed > p.available {
+		return errors.Errorf("DISK FULL: the cart needs %d more bytes (%s) but only %d bytes (%s) are available at the destination", p.needed, formatBytes(p.needed), p.available, formatBytes(p.available))
+	}
+	return nil
+}
+
+// print Lists every job and what's left of it, then the totals.
+func (p *plan) print(w io.Writer) {
+	for i, j := range p.jobs {
+		var action string
+		switch left := p.pending[i]; {
+		case left == 0:
+			action = "present"
+		case left < j.file.Size:
+			action = fmt.Sprintf("resume, %s left", formatBytes(left))
+		default:
+			action = "download"
+		}
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\n", j.acc, j.file.Name, formatBytes(j.file.Size), action, j.path)
+	}
+	fmt.Fprintf(w, "%d file(s), %s in total, %s to download, %s available at the destination.\n", len(p.jobs), formatBytes(p.total), formatBytes(p.needed), formatBytes(p.available))
+}
+
+// pendingBytes Returns how many bytes of j have yet to be written to disk.
+// A file that's already the right size counts as present, its md5 is checked when it's downloaded.
+func pendingBytes(j job) uint64 {
+	if fi, err := os.Stat(j.path); err == nil && fi.Mode().IsRegular() && uint64(fi.Size()) == j.file.Size {
+		return 0
+	}
+	partial := j.path + partialSuffix
+	if state, err := loadChunkState(partial + chunkStateSuffix); err == nil && state.Size == j.file.Size {
+		// Chunks that aren't done yet are holes in the partial download that will take up space.
+		return j.file.Size - uint64(state.doneBytes())
+	}
+	if fi, err := os.Stat(partial); err == nil && uint64(fi.Size()) <= j.file.Size {
+		return j.file.Size - uint64(fi.Size())
+	}
+	return j.file.Size
+}
+
+// availableBytes Returns how many bytes are free on the filesystem path is on,
+// going by its nearest existing parent if path doesn't exist yet.
+func availableBytes(path string) (uint64, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return 0, errors.Wrapf(err, "couldn't resolve destination: %s", path)
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, errors.Wrapf(err, "couldn't check available disk space at: %s", dir)
+	}
+	// Available blocks * size per block = available space in bytes
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -0,0 +1,97 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitre/fusera/fuseralib"
+)
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sracp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, n int) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, n), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) fuseralib.File {
+		return fuseralib.File{Name: name, Size: 1000}
+	}
+	write("present", 1000)
+	write("partial"+partialSuffix, 400)
+	write("oversized"+partialSuffix, 2000)
+	write("chunked"+partialSuffix, 1000)
+	state := newChunkState(&fuseralib.File{Size: 1000}, 300)
+	state.Done[0], state.Done[3] = true, true
+	if err := state.save(filepath.Join(dir, "chunked"+partialSuffix+chunkStateSuffix)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pending uint64
+		action  string
+	}{
+		{"missing", 1000, "download"},
+		{"present", 0, "present"},
+		{"partial", 600, "resume, 600 B left"},
+		{"oversized", 1000, "download"},
+		// Chunk 3 is the last 100 bytes.
+		{"chunked", 600, "resume, 600 B left"},
+	}
+	var jobs []job
+	for _, tt := range tests {
+		jobs = append(jobs, job{acc: "SRR1", file: file(tt.name), path: filepath.Join(dir, tt.name)})
+	}
+	p, err := newPlan(jobs, filepath.Join(dir, "not", "made", "yet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	p.print(&out)
+	lines := strings.Split(out.String(), "\n")
+	for i, tt := range tests {
+		if p.pending[i] != tt.pending {
+			t.Errorf("%s has %d bytes pending, want %d", tt.name, p.pending[i], tt.pending)
+		}
+		if !strings.Contains(lines[i], "\t"+tt.action+"\t") {
+			t.Errorf("%s is planned as %q, want %q", tt.name, lines[i], tt.action)
+		}
+	}
+	if p.total != 5000 || p.needed != 3200 {
+		t.Errorf("plan totals %d bytes with %d needed, want 5000 with 3200", p.total, p.needed)
+	}
+	if p.available == 0 {
+		t.Error("no bytes available where the destination will be made")
+	}
+	if err := p.fits(); err != nil {
+		t.Errorf("3200 bytes don't fit: %v", err)
+	}
+	p.available = 3199
+	if err := p.fits(); err == nil || !strings.Contains(err.Error(), "DISK FULL") {
+		t.Errorf("err = %v, want DISK FULL", err)
+	}
+}
@@ -0,0 +1,488 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The ways progress can be shown.
+const (
+	// progressAuto Picks tty when stdout is a terminal, json on stderr otherwise.
+	progressAuto = "auto"
+	// progressTTY Redraws a display of every file being downloaded and the cart as a whole.
+	progressTTY = "tty"
+	// progressJSON Writes one JSON event per line, for scripts and logs.
+	progressJSON = "json"
+	// progressNone Shows nothing, as when silent.
+	progressNone = "none"
+)
+
+// The statuses a file can finish with.
+const (
+	statusDownloaded = "downloaded"
+	statusPresent    = "present"
+	statusFailed     = "failed"
+)
+
+// How many files in flight the tty display lists before summing up the rest.
+const maxActiveLines = 10
+
+// resolveProgress Returns the way progress should be shown, given the mode asked for.
+func resolveProgress(mode string, silent bool) (string, error) {
+	if silent {
+		return progressNone, nil
+	}
+	switch mode {
+	case progressTTY, progressJSON, progressNone:
+		return mode, nil
+	case progressAuto, "":
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return progressTTY, nil
+		}
+		return progressJSON, nil
+	}
+	return "", errors.Errorf("unknown progress mode: %s, must be one of %s, %s, %s, or %s", mode, progressAuto, progressTTY, progressJSON, progressNone)
+}
+
+// progressOut Returns where progress shown in mode goes. Json that auto picked goes to stderr,
+// so it doesn't mix with whatever stdout is piped to, unless json was asked for by name.
+func progressOut(mode string) io.Writer {
+	if mode == progressJSON && progressMode != progressJSON {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// tally How many files ended up which way.
+type tally struct {
+	Files      int `json:"files"`
+	Downloaded int `json:"downloaded"`
+	Present    int `json:"present"`
+	Failed     int `json:"failed"`
+}
+
+// finished Returns how many of the files are done with, one way or another.
+func (t *tally) finished() int {
+	return t.Downloaded + t.Present + t.Failed
+}
+
+// transfer How far along the bytes of the cart are.
+type transfer struct {
+	Bytes          uint64  `json:"bytes"`
+	TotalBytes     uint64  `json:"totalBytes"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	EtaSeconds     float64 `json:"etaSeconds"`
+}
+
+// record How a file, or an accession that never got as far as its files, turned out.
+type record struct {
+	Accession string  `json:"accession"`
+	Name      string  `json:"name,omitempty"`
+	Path      string  `json:"path,omitempty"`
+	Status    string  `json:"status,omitempty"`
+	Size      uint64  `json:"size,omitempty"`
+	Md5       string  `json:"md5,omitempty"`
+	Duration  float64 `json:"durationSeconds,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// event One line of json progress.
+type event struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	*record
+	*tally
+	*transfer
+}
+
+// progress Keeps count of the bytes on disk for every file in the cart, across all workers,
+// and shows how the downloads are going in the mode asked for.
+type progress struct {
+	mode string
+	out  io.Writer
+
+	mu sync.Mutex
+	// done Bytes on disk for each file, by accession/name.
+	done   map[string]int64
+	active map[string]job
+	total  tally
+	accs   map[string]*tally
+	bytes  uint64
+	// transferred Bytes read over the network this run, for working out the rate.
+	transferred int64
+	rate        float64
+	lastTick    time.Time
+	lastBytes   int64
+	// drawn How many lines of the tty display are on screen.
+	drawn int
+}
+
+func newProgress(mode string, out io.Writer) *progress {
+	return &progress{
+		mode:     mode,
+		out:      out,
+		done:     make(map[string]int64),
+		active:   make(map[string]job),
+		accs:     make(map[string]*tally),
+		lastTick: time.Now(),
+	}
+}
+
+func jobKey(acc, name string) string {
+	return acc + "/" + name
+}
+
+// queue Adds jobs to the cart.
+func (p *progress) queue(jobs []job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, j := range jobs {
+		t, ok := p.accs[j.acc]
+		if !ok {
+			t = &tally{}
+			p.accs[j.acc] = t
+		}
+		t.Files++
+		p.total.Files++
+		p.bytes += j.file.Size
+	}
+}
+
+// failAccession Shows that an accession failed before any of its files could be downloaded.
+func (p *progress) failAccession(acc, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.mode {
+	case progressTTY:
+		p.printLocked("accession %s failed: %s\n", acc, strings.TrimSpace(reason))
+	case progressJSON:
+		p.emitLocked(&event{Event: "accession", record: &record{Accession: acc, Status: statusFailed, Error: reason}})
+	}
+}
+
+// failFile Counts a file of an accession as failed before it was queued, as when it can't be placed,
+// leaving the accession's other files to carry on.
+func (p *progress) failFile(acc, name, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.accs[acc]
+	if !ok {
+		t = &tally{}
+		p.accs[acc] = t
+	}
+	t.Files++
+	t.Failed++
+	p.total.Files++
+	p.total.Failed++
+	switch p.mode {
+	case progressTTY:
+		p.printLocked("failed to download %s/%s: %s\n", acc, name, strings.TrimSpace(reason))
+	case progressJSON:
+		p.emitLocked(&event{Event: "finish", record: &record{Accession: acc, Name: name, Status: statusFailed, Error: reason}})
+	}
+}
+
+// skipAccession Shows that an accession had none of the files asked for.
+func (p *progress) skipAccession(acc string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.mode {
+	case progressTTY:
+		p.printLocked("accession %s finished: no files to download.\n", acc)
+	case progressJSON:
+		p.emitLocked(&event{Event: "accession", record: &record{Accession: acc}, tally: &tally{}})
+	}
+}
+
+// start Records that a worker has picked up j.
+func (p *progress) start(j job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := jobKey(j.acc, j.file.Name)
+	p.active[key] = j
+	if p.mode == progressJSON {
+		p.emitLocked(&event{Event: "start", record: &record{Accession: j.acc, Name: j.file.Name, Path: j.path, Size: j.file.Size, Md5: j.file.Md5Hash}})
+	}
+}
+
+// set Records that n bytes of the file are on disk, as when a download starts or resumes.
+func (p *progress) set(key string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] = n
+}
+
+func (p *progress) add(key string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] += n
+	p.transferred += n
+}
+
+// discard Takes back n bytes of the file that have to be downloaded again.
+func (p *progress) discard(key string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] -= n
+}
+
+// finish Records how r went and returns its record.
+func (p *progress) finish(r result) record {
+	rec := record{
+		Accession: r.acc,
+		Name:      r.file.Name,
+		Path:      r.path,
+		Size:      r.file.Size,
+		Md5:       r.file.Md5Hash,
+		Duration:  r.elapsed.Seconds(),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, jobKey(r.acc, r.file.Name))
+	t := p.accs[r.acc]
+	switch {
+	case r.err != nil:
+		rec.Status = statusFailed
+		rec.Error = r.err.Error()
+		t.Failed++
+		p.total.Failed++
+	case r.skipped:
+		rec.Status = statusPresent
+		t.Present++
+		p.total.Present++
+	default:
+		rec.Status = statusDownloaded
+		t.Downloaded++
+		p.total.Downloaded++
+	}
+	switch p.mode {
+	case progressTTY:
+		if r.err != nil {
+			p.printLocked("failed to download %s/%s: %s\n", r.acc, r.file.Name, r.err.Error())
+		}
+	case progressJSON:
+		p.emitLocked(&event{Event: "finish", record: &rec})
+	}
+	p.endAccessionLocked(r.acc)
+	return rec
+}
+
+// endAccession Shows how an accession turned out, once none of its files are left.
+func (p *progress) endAccession(acc string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endAccessionLocked(acc)
+}
+
+// endAccessionLocked LOCKS_REQUIRED(p.mu)
+func (p *progress) endAccessionLocked(acc string) {
+	t, ok := p.accs[acc]
+	if !ok || t.finished() != t.Files {
+		return
+	}
+	switch p.mode {
+	case progressTTY:
+		p.printLocked("accession %s finished: %d file(s) successfully downloaded, %d already present, %d failed.\n", acc, t.Downloaded, t.Present, t.Failed)
+	case progressJSON:
+		p.emitLocked(&event{Event: "accession", record: &record{Accession: acc}, tally: t})
+	}
+}
+
+// totals Returns how every file in the cart has turned out so far.
+func (p *progress) totals() tally {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// transferLocked LOCKS_REQUIRED(p.mu)
+func (p *progress) transferLocked() *transfer {
+	var done int64
+	for _, n := range p.done {
+		done += n
+	}
+	t := &transfer{Bytes: uint64(done), TotalBytes: p.bytes, BytesPerSecond: p.rate}
+	if p.rate > 0 && t.TotalBytes > t.Bytes {
+		t.EtaSeconds = float64(t.TotalBytes-t.Bytes) / p.rate
+	}
+	return t
+}
+
+// tickLocked Updates the rate, smoothing it so the ETA doesn't jump around. LOCKS_REQUIRED(p.mu)
+func (p *progress) tickLocked() {
+	now := time.Now()
+	elapsed := now.Sub(p.lastTick).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(p.transferred-p.lastBytes) / elapsed
+	if p.rate == 0 {
+		p.rate = current
+	} else {
+		p.rate = 0.7*p.rate + 0.3*current
+	}
+	p.lastTick = now
+	p.lastBytes = p.transferred
+}
+
+// report Shows progress until stop is closed: redrawing the tty display often, or writing a json event now and then.
+func (p *progress) report(stop <-chan struct{}) {
+	interval := 5 * time.Second
+	switch p.mode {
+	case progressTTY:
+		interval = 500 * time.Millisecond
+	case progressNone:
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.tickLocked()
+			if p.mode == progressTTY {
+				p.drawLocked()
+			} else {
+				total := p.total
+				p.emitLocked(&event{Event: "progress", tally: &total, transfer: p.transferLocked()})
+			}
+			p.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// close Shows the final state of the cart.
+func (p *progress) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tickLocked()
+	switch p.mode {
+	case progressTTY:
+		p.drawLocked()
+		// Leave the last display on screen.
+		p.drawn = 0
+	case progressJSON:
+		total := p.total
+		p.emitLocked(&event{Event: "done", tally: &total, transfer: p.transferLocked()})
+	}
+}
+
+// printLocked Prints a message above the tty display. LOCKS_REQUIRED(p.mu)
+func (p *progress) printLocked(format string, a ...interface{}) {
+	p.clearLocked()
+	fmt.Fprintf(p.out, format, a...)
+	p.drawLocked()
+}
+
+// clearLocked LOCKS_REQUIRED(p.mu)
+func (p *progress) clearLocked() {
+	if p.drawn > 0 {
+		// Move up to the first line drawn and clear everything below.
+		fmt.Fprintf(p.out, "\033[%dA\033[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+// drawLocked LOCKS_REQUIRED(p.mu)
+func (p *progress) drawLocked() {
+	p.clearLocked()
+	if p.total.Files == 0 {
+		return
+	}
+	keys := make([]string, 0, len(p.active))
+	for k := range p.active {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, maxActiveLines+2)
+	for i, k := range keys {
+		if i == maxActiveLines {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(keys)-maxActiveLines))
+			break
+		}
+		f := p.active[k]
+		line := fmt.Sprintf("  %s  %s", k, formatBytes(uint64(p.done[k])))
+		if f.file.Size > 0 {
+			line += fmt.Sprintf(" of %s (%d%%)", formatBytes(f.file.Size), uint64(p.done[k])*100/f.file.Size)
+		}
+		lines = append(lines, line)
+	}
+	t := p.transferLocked()
+	summary := fmt.Sprintf("%d of %d file(s) finished, %d failed, %s of %s, %s/s", p.total.finished(), p.total.Files, p.total.Failed, formatBytes(t.Bytes), formatBytes(t.TotalBytes), formatBytes(uint64(t.BytesPerSecond)))
+	if t.EtaSeconds > 0 {
+		summary += ", ETA " + (time.Duration(t.EtaSeconds) * time.Second).String()
+	}
+	lines = append(lines, summary)
+	fmt.Fprintln(p.out, strings.Join(lines, "\n"))
+	p.drawn = len(lines)
+}
+
+// emitLocked Writes e as a line of json. LOCKS_REQUIRED(p.mu)
+func (p *progress) emitLocked(e *event) {
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		panic("INTERNAL ERROR: couldn't encode progress event")
+	}
+	fmt.Fprintln(p.out, string(line))
+}
+
+// meter Counts the bytes read from a download towards its progress, holding them back if they'd break the bandwidth cap.
+type meter struct {
+	r        io.Reader
+	key      string
+	limiter  *limiter
+	progress *progress
+}
+
+func (m *meter) Read(p []byte) (int, error) {
+	if m.limiter != nil && len(p) > m.limiter.burst {
+		// Small reads keep the cap smooth.
+		p = p[:m.limiter.burst]
+	}
+	n, err := m.r.Read(p)
+	if n > 0 {
+		m.limiter.wait(n)
+		m.progress.add(m.key, int64(n))
+	}
+	return n, err
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
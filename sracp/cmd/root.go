@@ -21,18 +21,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"syscall"
 	"time"
 
-	"github.com/mitre/fusera/gps"
 	"github.com/mitre/fusera/info"
 
-	"github.com/mitre/fusera/fuseralib"
-	"github.com/mitre/fusera/sdl"
-
-	"github.com/cavaliercoder/grab"
 	"github.com/mattrbianchi/twig"
 	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -40,6 +35,8 @@ import (
 
 var (
 	debug bool
+
+	progressMode string
 )
 
 func init() {
@@ -48,37 +45,15 @@ func init() {
 		panic("INTERNAL ERROR: could not bind debug flag to debug environment variable")
 	}
 
-	rootCmd.Flags().StringVarP(&flags.Location, "location", "l", "", flags.LocationMsg)
-	if err := viper.BindPFlag("location", rootCmd.Flags().Lookup("location")); err != nil {
-		panic("INTERNAL ERROR: could not bind location flag to location environment variable")
-	}
-
-	rootCmd.Flags().StringVarP(&flags.Accession, "accession", "a", "", flags.AccessionMsg)
-	if err := viper.BindPFlag("accession", rootCmd.Flags().Lookup("accession")); err != nil {
-		panic("INTERNAL ERROR: could not bind accession flag to accession environment variable")
-	}
-
-	rootCmd.Flags().StringVarP(&flags.Tokenpath, "token", "t", "", flags.TokenMsg)
-	if err := viper.BindPFlag("token", rootCmd.Flags().Lookup("token")); err != nil {
-		panic("INTERNAL ERROR: could not bind token flag to token environment variable")
-	}
-
-	rootCmd.Flags().StringVarP(&flags.Filetype, "filetype", "f", "", flags.FiletypeMsg)
-	if err := viper.BindPFlag("filetype", rootCmd.Flags().Lookup("filetype")); err != nil {
-		panic("INTERNAL ERROR: could not bind filetype flag to filetype environment variable")
-	}
+	flags.Register(rootCmd.PersistentFlags(), flags.Output...)
+	flags.Register(rootCmd.PersistentFlags(), flags.Common...)
+	flags.Register(rootCmd.PersistentFlags(), flags.RegionPolicyName)
+	flags.Register(rootCmd.PersistentFlags(), flags.RetriesName, flags.ConcurrencyName, flags.LimitRateName, flags.OrderName, flags.LayoutName, flags.SummaryName)
+	flags.Register(rootCmd.Flags(), flags.ConnectionsName, flags.ChunkSizeName, flags.DryRunName, flags.ListName, flags.JSONName)
 
-	rootCmd.Flags().StringVarP(&flags.Endpoint, "endpoint", "e", "https://www.ncbi.nlm.nih.gov/Traces/sdl/1/retrieve", flags.EndpointMsg)
-	if err := viper.BindPFlag("endpoint", rootCmd.Flags().Lookup("endpoint")); err != nil {
-		panic("INTERNAL ERROR: could not bind endpoint flag to endpoint environment variable")
-	}
-
-	rootCmd.Flags().IntVarP(&flags.Batch, "batch", "", flags.BatchDefault, flags.BatchMsg)
-	if err := viper.BindPFlag("batch", rootCmd.Flags().Lookup("batch")); err != nil {
-		panic("INTERNAL ERROR: could not bind batch flag to batch environment variable")
-	}
+	rootCmd.PersistentFlags().StringVarP(&progressMode, "progress", "", progressAuto, "How to show progress: tty for a live display, json for one event per line, or none. auto picks tty when writing to a terminal and json on stderr otherwise.")
 
-	viper.SetEnvPrefix("dbgap")
+	viper.SetEnvPrefix(flags.EnvPrefix)
 	viper.AutomaticEnv()
 
 	info.BinaryName = "sracp"
@@ -89,172 +64,115 @@ var rootCmd = &cobra.Command{
 	Short:   "A tool similar to cp that allows a user to download accessions - " + info.Version,
 	Long:    ``,
 	Version: info.Version,
-	Args:    cobra.ExactArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) (err error) {
+	Args: func(cmd *cobra.Command, args []string) error {
+		// Args are checked before PersistentPreRun, so list may still only be set in the environment.
+		flags.FoldEnvVarsIntoFlagValues(cmd.Flags())
+		if flags.List {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	// Execute prints errors itself, and a failed download isn't a usage problem.
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		setConfig()
-		flags.FoldEnvVarsIntoFlagValues()
-		tokenpath := flags.FoldNgcIntoToken(flags.Tokenpath, flags.NgcPath)
-		var token []byte
-		if tokenpath != "" {
-			token, err = flags.ResolveNgcFile(tokenpath)
-			if err != nil {
-				return err
-			}
+		flags.FoldEnvVarsIntoFlagValues(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if flags.List {
+			return listCart()
 		}
-		var accs []string
-		if flags.Accession != "" {
-			accs, err = flags.ResolveAccession(flags.Accession)
-			if err != nil {
-				return err
-			}
+		bandwidth, chunkBytes, lay, mode, err := resolveCopyFlags()
+		if err != nil {
+			return err
 		}
-		var types map[string]bool
-		if flags.Filetype != "" {
-			types, err = flags.ResolveFileType(flags.Filetype)
+		started := time.Now()
+
+		path := args[0]
+		// Test whether we can write to this location. If not, fail here.
+		// A dry run doesn't write anything, so it doesn't need to.
+		if !flags.DryRun {
+			err = os.MkdirAll(filepath.Join(path, ".test"), 0755)
 			if err != nil {
-				return err
+				fmt.Printf("It seems like sracp cannot make directories under %s. Please check that you have correct permissions to write to that path.\n", path)
+				os.Exit(1)
 			}
 		}
 
-		path := args[0]
-		// Test whether we can write to this location. If not, fail here.
-		err = os.MkdirAll(filepath.Join(path, ".test"), 0755)
+		c, err := openCart(nil, false)
 		if err != nil {
-			fmt.Printf("It seems like sracp cannot make directories under %s. Please check that you have correct permissions to write to that path.\n", path)
-			os.Exit(1)
+			return err
 		}
 
-		// Location takes longest if there's a failure, so validate it last.
-		var locator gps.Locator
-		if flags.Location != "" {
-			locator, err = gps.NewManualLocation(flags.Location)
-			if err != nil {
-				twig.Debug(err)
-				fmt.Println(err)
-				return err
-			}
-		} else { // figure out which locator we'll need
-			locator, err = gps.GenerateLocator()
-			if err != nil {
-				twig.Debug(err)
-				fmt.Println(err)
-				return errors.New("no location provided")
-			}
+		var o outcome
+		p := newProgress(mode, progressOut(mode))
+		jobs := c.jobs(lay, path, p, &o)
+		sortJobs(jobs, flags.Order)
+		if err := checkCollisions(jobs); err != nil {
+			return err
 		}
 
-		info.LoadAccessionMap(accs)
-		var API = sdl.NewSDL()
-		var param = sdl.NewParam(accs, locator, token, sdl.SetAcceptCharges(flags.AwsProfile, flags.GcpProfile), types)
-		API.Param = param
-		API.URL = flags.Endpoint
-		if flags.Verbose {
-			fmt.Printf("Communicating with SDL API at: %s\n", flags.Endpoint)
-			fmt.Printf("Using token at: %s\n", tokenpath)
-			fmt.Printf("Contents of token: %s\n", string(token[:]))
-			fmt.Printf("Limiting file types to: %v\n", types)
-			fmt.Printf("Giving locality as: %s\n", locator.LocalityType())
-			fmt.Printf("Requesting accessions in batches of: %d\n", flags.Batch)
+		// Check the whole cart against the filesystem it's going to before
+		// downloading anything, so it can't run out of space halfway through.
+		pl, err := newPlan(jobs, path)
+		if err != nil {
+			return err
 		}
-		accessions, warnings := fuseralib.FetchAccessions(API, accs, flags.Batch)
-		if warnings != nil {
-			if !flags.Silent {
-				fmt.Println(err.Error())
-			}
+		if flags.DryRun {
+			pl.print(os.Stdout)
+			// Accessions that couldn't be resolved would fail the real run too.
+			return o.err("accession(s) or file(s)")
 		}
-		if len(accessions) == 0 {
-			if !flags.Silent {
-				fmt.Println("It seems like none of the accessions were successful, fusera is shutting down.")
-			}
-			os.Exit(1)
+		if err := pl.fits(); err != nil {
+			return err
 		}
 
-		for _, a := range accessions {
-			err := os.MkdirAll(filepath.Join(path, a.ID), 0755)
-			if err != nil {
-				fmt.Printf("Issue creating directory for %s: %s\n", a.ID, err.Error())
-				continue
-			}
-			// create a batch of urls to download and collect combined file size to still do disk check.
-			urls := make([]string, 0, len(accs))
-			var totalFileSize uint64
-			for _, f := range a.Files {
-				// if the API returns filetypes the user didn't want, still don't copy them.
-				if types != nil {
-					if _, ok := types[f.Type]; !ok {
-						continue
-					}
-				}
-				if f.Link == "" {
-					fmt.Printf("file: %s had no link, moving on to download other files\n", f.Name)
-					continue
-				}
-				urls = append(urls, f.Link)
-				totalFileSize += f.Size
-			}
-			// Check available disk space and see if file is larger.
-			// If so, print out error message saying such, refuse to use curl, and move on.
-			var stat syscall.Statfs_t
-			wd, err := os.Getwd()
-			if err := syscall.Statfs(wd, &stat); err != nil {
+		cp := &copier{
+			reader:      c.reader,
+			limiter:     newLimiter(bandwidth),
+			progress:    p,
+			connections: flags.Connections,
+			chunkSize:   int64(chunkBytes),
+		}
+		runJobs(jobs, p, &o, func(j job) (bool, error) {
+			return cp.download(j.acc, j.file, j.path)
+		})
+		if flags.Summary != "" {
+			if err := writeSummary(flags.Summary, started, p.totals(), o.records); err != nil {
 				return err
 			}
-
-			// Available blocks * size per block = available space in bytes
-			availableBytes := stat.Bavail * uint64(stat.Bsize)
-			if availableBytes < totalFileSize {
-				fmt.Printf("DISK FULL: It appears there are only %d available bytes on disk and the batch of files in accession %s is %d bytes.", availableBytes, a.ID, totalFileSize)
-				continue
-			}
-
-			respch, err := grab.GetBatch(0, filepath.Join(path, a.ID), urls...)
-			if err != nil {
-				twig.Debugf("%v\n", err)
-			}
-			// start a ticker to update progress every 200ms
-			t := time.NewTicker(time.Second)
-
-			// monitor downloads
-			completed := 0
-			inProgress := 0
-			responses := make([]*grab.Response, 0)
-			for completed < len(urls) {
-				select {
-				case resp := <-respch:
-					// a new response has been received and has started downloading
-					// (nil is received once, when the channel is closed by grab)
-					if resp != nil {
-						responses = append(responses, resp)
-					}
-
-				case <-t.C:
-
-					// update completed downloads
-					for i, resp := range responses {
-						if resp != nil && resp.IsComplete() {
-							// mark completed
-							responses[i] = nil
-							completed++
-						}
-					}
-
-					// update downloads in progress
-					inProgress = 0
-					for _, resp := range responses {
-						if resp != nil && !resp.IsComplete() {
-							inProgress++
-						}
-					}
-				}
-			}
-
-			t.Stop()
-
-			fmt.Printf("accession %s finished: %d file(s) successfully downloaded.\n", a.ID, len(urls))
 		}
-		return nil
+		return o.err("download(s)")
 	},
 }
 
+// resolveCopyFlags Checks the flags for how files are copied, returning the bandwidth cap, chunk size, layout, and progress mode they ask for.
+func resolveCopyFlags() (bandwidth, chunkBytes uint64, lay *layout, mode string, err error) {
+	if flags.Concurrency < 1 {
+		return 0, 0, nil, "", errors.Errorf("concurrency must be at least 1, got %d", flags.Concurrency)
+	}
+	if bandwidth, err = parseRate(flags.LimitRate); err != nil {
+		return 0, 0, nil, "", err
+	}
+	if flags.Connections < 1 {
+		return 0, 0, nil, "", errors.Errorf("connections must be at least 1, got %d", flags.Connections)
+	}
+	if chunkBytes, err = flags.ResolveSize(flags.ChunkSize); err != nil {
+		return 0, 0, nil, "", err
+	}
+	if err = sortJobs(nil, flags.Order); err != nil {
+		return 0, 0, nil, "", err
+	}
+	if lay, err = parseLayout(flags.Layout); err != nil {
+		return 0, 0, nil, "", err
+	}
+	if mode, err = resolveProgress(progressMode, flags.Silent); err != nil {
+		return 0, 0, nil, "", err
+	}
+	return bandwidth, chunkBytes, lay, mode, nil
+}
+
 // Execute runs the root command of sracp, which copies files from the cloud to a local file system.
 func Execute() {
 	if os.Geteuid() == 0 {
@@ -271,3 +189,18 @@ func setConfig() {
 	// If debug flag gets set, print debug statements.
 	twig.SetDebug(debug)
 }
+
+// listCart Prints what's in the accessions given by flag, asking the SDL API for only their metadata.
+func listCart() error {
+	// The listing carries each accession's errors, so the SDL API needn't print them too.
+	flags.Silent = true
+	if flags.JSON {
+		// Anything else printed would break the json.
+		flags.Verbose = false
+	}
+	c, err := openCart(nil, true)
+	if err != nil {
+		return err
+	}
+	return fuseralib.WriteListing(os.Stdout, c.accessions, flags.JSON)
+}
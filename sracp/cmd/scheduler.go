@@ -0,0 +1,152 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+)
+
+// The orders files in the cart can be downloaded in.
+const (
+	orderSmallest = "smallest"
+	orderLargest  = "largest"
+	orderCart     = "cart"
+)
+
+// job A file to download and where it goes.
+type job struct {
+	acc  string
+	file fuseralib.File
+	path string
+}
+
+// result How a job went.
+type result struct {
+	job
+	skipped bool
+	err     error
+	elapsed time.Duration
+}
+
+// sortJobs Puts jobs in the order asked for. Jobs of the same size keep the order of the cart.
+func sortJobs(jobs []job, order string) error {
+	switch order {
+	case orderSmallest:
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].file.Size < jobs[j].file.Size })
+	case orderLargest:
+		sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].file.Size > jobs[j].file.Size })
+	case orderCart:
+	default:
+		return errors.Errorf("unknown download order: %s, must be one of %s, %s, or %s", order, orderSmallest, orderLargest, orderCart)
+	}
+	return nil
+}
+
+// schedule Runs every job across concurrency workers, no matter which accession it's from,
+// and sends how each one went on the returned channel, which is closed once they're all done.
+func schedule(jobs []job, concurrency int, run func(job) (bool, error)) <-chan result {
+	queue := make(chan job)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				started := time.Now()
+				skipped, err := run(j)
+				results <- result{job: j, skipped: skipped, err: err, elapsed: time.Since(started)}
+			}
+		}()
+	}
+	go func() {
+		for _, j := range jobs {
+			queue <- j
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// limiter A token bucket capping the combined rate of every download.
+type limiter struct {
+	// burst The most bytes let through at once.
+	burst int
+
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter Returns a limiter letting through bytesPerSecond, or nil if that's 0, meaning no cap.
+func newLimiter(bytesPerSecond uint64) *limiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	burst := 32 * 1024
+	if bytesPerSecond < uint64(burst) {
+		burst = int(bytesPerSecond)
+	}
+	return &limiter{
+		burst:  burst,
+		rate:   float64(bytesPerSecond),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait Blocks until n bytes can be let through without going over the cap.
+// A nil limiter never blocks.
+func (l *limiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	// Take the bytes now and sleep off any debt, so waiting readers queue up fairly.
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / l.rate * float64(time.Second)))
+	}
+}
+
+// parseRate Parses a rate in bytes per second, such as 500K or 10M. Suffixes are powers of 1024.
+// An empty rate or 0 means no cap.
+func parseRate(limit string) (uint64, error) {
+	rate := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(limit)), "/S")
+	n, err := flags.ResolveSize(rate)
+	if err != nil {
+		return 0, errors.Errorf("couldn't parse bandwidth limit: %s, expected a number of bytes per second like 500K or 10M", limit)
+	}
+	return n, nil
+}
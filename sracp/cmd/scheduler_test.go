@@ -0,0 +1,140 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mitre/fusera/fuseralib"
+)
+
+func TestSortJobs(t *testing.T) {
+	jobs := []job{
+		{acc: "SRR1", file: fuseralib.File{Name: "b", Size: 20}},
+		{acc: "SRR1", file: fuseralib.File{Name: "a", Size: 10}},
+		{acc: "SRR2", file: fuseralib.File{Name: "c", Size: 20}},
+		{acc: "SRR2", file: fuseralib.File{Name: "d", Size: 30}},
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{orderSmallest, []string{"a", "b", "c", "d"}},
+		{orderLargest, []string{"d", "b", "c", "a"}},
+		{orderCart, []string{"b", "a", "c", "d"}},
+	}
+	for _, tt := range tests {
+		sorted := append([]job(nil), jobs...)
+		if err := sortJobs(sorted, tt.order); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, j := range sorted {
+			names = append(names, j.file.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.order, names, tt.want)
+		}
+	}
+	if err := sortJobs(jobs, "random"); err == nil {
+		t.Error("sorted by an unknown order")
+	}
+}
+
+func TestScheduleRunsEveryJobWithinConcurrency(t *testing.T) {
+	var jobs []job
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		jobs = append(jobs, job{acc: "SRR1", file: fuseralib.File{Name: name}})
+	}
+	var (
+		mu            sync.Mutex
+		running, most int
+	)
+	results := schedule(jobs, 3, func(j job) (bool, error) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return j.file.Name == "a", nil
+	})
+	seen := make(map[string]bool)
+	for r := range results {
+		seen[r.file.Name] = true
+		if r.skipped != (r.file.Name == "a") {
+			t.Errorf("%s: skipped = %v", r.file.Name, r.skipped)
+		}
+	}
+	if len(seen) != len(jobs) {
+		t.Errorf("%d of %d jobs ran", len(seen), len(jobs))
+	}
+	if most != 3 {
+		t.Errorf("%d jobs ran at once, want 3", most)
+	}
+}
+
+func TestLimiterCapsRate(t *testing.T) {
+	if newLimiter(0) != nil {
+		t.Fatal("newLimiter(0) should be nil, for no cap")
+	}
+	// A nil limiter never blocks.
+	var none *limiter
+	none.wait(1 << 30)
+
+	l := newLimiter(64 * 1024)
+	started := time.Now()
+	// The first burst goes through at once, the next has to wait for it to be paid off.
+	l.wait(32 * 1024)
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Errorf("first burst took %v, want no wait", elapsed)
+	}
+	l.wait(32 * 1024)
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Errorf("64K took %v at 64K per second, want about half a second", elapsed)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  uint64
+		err   bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"1000", 1000, false},
+		{"500K", 500 * 1024, false},
+		{"10M/s", 10 * 1024 * 1024, false},
+		{"1g", 1 << 30, false},
+		{" 2KB/S ", 2048, false},
+		{"fast", 0, true},
+		{"-1M", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.limit)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseRate(%q) = %d, %v, want %d", tt.limit, got, err, tt.want)
+		}
+	}
+}
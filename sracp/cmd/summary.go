@@ -0,0 +1,53 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// summary What a run of sracp did to every accession and file in the cart, written at the end of the run.
+type summary struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Totals   tally     `json:"totals"`
+	Files    []record  `json:"files"`
+}
+
+// writeSummary Writes the records of a run started at started to path as json.
+func writeSummary(path string, started time.Time, total tally, records []record) error {
+	s := summary{
+		Started:  started.UTC(),
+		Finished: time.Now().UTC(),
+		Totals:   total,
+		Files:    records,
+	}
+	if s.Files == nil {
+		s.Files = []record{}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "couldn't encode summary")
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return errors.Wrapf(err, "couldn't write summary file at: %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Modifications Copyright 2018 The MITRE Corporation
+// Authors: Matthew Bianchi
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattrbianchi/twig"
+	"github.com/mitre/fusera/awsutil"
+	"github.com/mitre/fusera/flags"
+	"github.com/mitre/fusera/fuseralib"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	uploadRegion   string
+	uploadEndpoint string
+	uploadProfile  string
+	uploadPartSize string
+)
+
+func init() {
+	uploadCmd.Flags().StringVarP(&uploadRegion, "region", "", "us-east-1", "The region of the destination bucket. Ignored for gs:// buckets.")
+	uploadCmd.Flags().StringVarP(&uploadEndpoint, "bucket-endpoint", "", "", "Upload to this S3 compatible endpoint instead of AWS S3 or Google Cloud Storage.")
+	uploadCmd.Flags().StringVarP(&uploadProfile, "profile", "", "", "The profile in the shared credentials file to upload with. Defaults to the aws-profile for s3:// buckets and the gcp-profile for gs:// buckets, whose credentials must be HMAC keys.")
+	uploadCmd.Flags().StringVarP(&uploadPartSize, "part-size", "", "16M", "How big each part of a multipart upload is, and so how much of each file is held in memory at once. Must be at least 5M. Files too big to upload in 10000 parts of this size are uploaded in bigger parts.")
+	rootCmd.AddCommand(uploadCmd)
+}
+
+var uploadCmd = &cobra.Command{
+	Use:   "upload <s3://bucket/prefix | gs://bucket/prefix>",
+	Short: "Copy files straight into a bucket without touching local disk.",
+	Long: `Copy files straight into an S3 or Google Cloud Storage bucket, streaming each one through a multipart upload without touching local disk.
+Files are placed under the prefix by --layout, and the md5 of each is checked on the way through. Uploads that fail or don't match are tried again.`,
+	Example: "sracp upload s3://my-bucket/sra -a SRR1234567,SRR1234568 --layout '{type}/{accession}_{name}'",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bandwidth, _, lay, mode, err := resolveCopyFlags()
+		if err != nil {
+			return err
+		}
+		u, prefix, err := newUploader(args[0])
+		if err != nil {
+			return err
+		}
+		started := time.Now()
+
+		c, err := openCart(nil, false)
+		if err != nil {
+			return err
+		}
+
+		var o outcome
+		p := newProgress(mode, progressOut(mode))
+		// Laid out under "/", so each path is the key under the prefix.
+		jobs := c.jobs(lay, "/", p, &o)
+		for i := range jobs {
+			jobs[i].path = path.Join(prefix, strings.TrimPrefix(jobs[i].path, "/"))
+		}
+		sortJobs(jobs, flags.Order)
+		if err := checkCollisions(jobs); err != nil {
+			return err
+		}
+
+		cp := &copier{
+			reader:   c.reader,
+			limiter:  newLimiter(bandwidth),
+			progress: p,
+		}
+		runJobs(jobs, p, &o, func(j job) (bool, error) {
+			return false, cp.upload(u, j.acc, j.file, j.path)
+		})
+		if flags.Summary != "" {
+			if err := writeSummary(flags.Summary, started, p.totals(), o.records); err != nil {
+				return err
+			}
+		}
+		return o.err("upload(s)")
+	},
+}
+
+// newUploader Returns an Uploader for the bucket in dest and the prefix keys go under.
+func newUploader(dest string) (*awsutil.Uploader, string, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Host == "" || (u.Scheme != "s3" && u.Scheme != "gs") {
+		return nil, "", errors.Errorf("destination must look like s3://bucket/prefix or gs://bucket/prefix, got: %s", dest)
+	}
+	partSize, err := flags.ResolveSize(uploadPartSize)
+	if err != nil {
+		return nil, "", err
+	}
+	if partSize < 5*1024*1024 {
+		return nil, "", errors.Errorf("part size must be at least 5M, got: %s", uploadPartSize)
+	}
+	region, endpoint := uploadRegion, uploadEndpoint
+	if u.Scheme == "gs" && endpoint == "" {
+		region, endpoint = "auto", awsutil.GcsEndpoint
+	}
+	profile := uploadProfile
+	if profile == "" {
+		profile = flags.SetProfile(u.Scheme)
+	}
+	up := awsutil.NewUploader(u.Host, region, endpoint, profile)
+	up.PartSize = int64(partSize)
+	return up, strings.Trim(u.Path, "/"), nil
+}
+
+// upload Streams f into key, checking its size and md5 on the way through.
+// An upload that fails or doesn't match is abandoned and tried again up to retries times.
+func (c *copier) upload(u *awsutil.Uploader, acc string, f fuseralib.File, key string) error {
+	var err error
+	for attempt := 0; attempt <= flags.Retries; attempt++ {
+		if attempt > 0 {
+			twig.Debugf("retrying upload of %s, attempt %d of %d: %s", f.Name, attempt, flags.Retries, err.Error())
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		err = c.uploadOnce(u, acc, &f, key)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *copier) uploadOnce(u *awsutil.Uploader, acc string, f *fuseralib.File, key string) error {
+	id := jobKey(acc, f.Name)
+	c.progress.set(id, 0)
+	body, err := c.reader.OpenRange(acc, f, 0)
+	if err != nil {
+		return errors.Wrapf(err, "couldn't start reading %s", f.Name)
+	}
+	defer body.Close()
+	h := md5.New()
+	counter := &countingWriter{}
+	in := io.TeeReader(&meter{r: body, key: id, limiter: c.limiter, progress: c.progress}, io.MultiWriter(h, counter))
+	// Checked before the upload is finished, so an object that doesn't match is never made.
+	return u.Upload(key, in, f.Size, func() error {
+		if f.Size > 0 && counter.n != f.Size {
+			return errors.Errorf("size mismatch for %s: expected %d bytes, uploaded %d bytes", f.Name, f.Size, counter.n)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); f.Md5Hash != "" && sum != f.Md5Hash {
+			return errors.Errorf("md5 mismatch for %s: expected %s, uploaded %s", f.Name, f.Md5Hash, sum)
+		}
+		return nil
+	})
+}
+
+type countingWriter struct {
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}